@@ -0,0 +1,86 @@
+package auth
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// APIKeyPrefix starts every API key so that keys are recognizable in logs and secret scanners
+const APIKeyPrefix = "gk_"
+
+// ScopeAll grants an API key access to every route
+const ScopeAll = "*"
+
+// ScopedResources are the first path segments of the authenticated routes, the resources an API key scope can name
+var ScopedResources = []string{"users", "tickets", "orders", "payments", "records", "api-keys", "2fa"}
+
+// ErrInvalidAPIKey is returned by an APIKeyResolver for unknown, revoked or expired keys;
+// any other error is a failure to resolve the key and is answered as such instead of with a 401
+var ErrInvalidAPIKey = errors.New("invalid api key")
+
+// APIKeyResolver resolves a presented API key to the principal it was issued to
+type APIKeyResolver func(key string) (Principal, error)
+
+// GenerateAPIKey returns a new API key
+func GenerateAPIKey() (string, error) {
+	secret, err := GenerateRefreshToken()
+	if err != nil {
+		return "", err
+	}
+	return APIKeyPrefix + secret, nil
+}
+
+// RouteScope returns the scope an API key needs for the matched route,
+// e.g. "orders:read" for GET /orders/date/... and "orders:write" for POST /orders
+func RouteScope(c *gin.Context) string {
+	resource := strings.Split(strings.TrimPrefix(c.FullPath(), "/"), "/")[0]
+	switch c.Request.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions:
+		return resource + ":read"
+	}
+	return resource + ":write"
+}
+
+// ValidScope reports whether scope is ScopeAll or a scope RouteScope can return, "<resource>:read" or "<resource>:write"
+func ValidScope(scope string) bool {
+	if scope == ScopeAll {
+		return true
+	}
+	resource, access, ok := strings.Cut(scope, ":")
+	if !ok || (access != "read" && access != "write") {
+		return false
+	}
+	for _, known := range ScopedResources {
+		if resource == known {
+			return true
+		}
+	}
+	return false
+}
+
+// authenticateAPIKey resolves key with resolver and checks that it is scoped for the current route
+func authenticateAPIKey(c *gin.Context, resolver APIKeyResolver, key string) (Principal, bool) {
+	if resolver == nil {
+		unauthorized(c, CodeAPIKeyInvalid, "API keys are not accepted")
+		return Principal{}, false
+	}
+	principal, err := resolver(key)
+	if errors.Is(err, ErrInvalidAPIKey) {
+		unauthorized(c, CodeAPIKeyInvalid, "API key is invalid, revoked or expired")
+		return Principal{}, false
+	}
+	if err != nil {
+		problem.Abort(c, err)
+		return Principal{}, false
+	}
+	if scope := RouteScope(c); !principal.HasScope(scope) && !principal.HasScope(ScopeAll) {
+		problem.Abort(c, problem.New(http.StatusForbidden, CodeInsufficientScope, "API key is missing scope "+scope))
+		return Principal{}, false
+	}
+	return principal, true
+}
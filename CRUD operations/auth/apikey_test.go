@@ -0,0 +1,104 @@
+package auth_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestValidScope(t *testing.T) {
+	for scope, want := range map[string]bool{
+		"*":              true,
+		"orders:read":    true,
+		"api-keys:write": true,
+		"2fa:read":       true,
+		"orders":         false,
+		"orders:delete":  false,
+		"orders:*":       false,
+		"menu:read":      false,
+		":read":          false,
+		"":               false,
+	} {
+		if got := auth.ValidScope(scope); got != want {
+			t.Errorf("ValidScope(%q) = %v, want %v", scope, got, want)
+		}
+	}
+}
+
+func TestRouteScope(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		method, route, path, want string
+	}{
+		{http.MethodGet, "/orders", "/orders", "orders:read"},
+		{http.MethodHead, "/orders/:id", "/orders/1", "orders:read"},
+		{http.MethodGet, "/orders/date/:from/:to", "/orders/date/2024-01-01/2024-02-01", "orders:read"},
+		{http.MethodPost, "/orders", "/orders", "orders:write"},
+		{http.MethodPatch, "/tickets/:id", "/tickets/1", "tickets:write"},
+		{http.MethodDelete, "/api-keys/:id", "/api-keys/1", "api-keys:write"},
+	} {
+		var got string
+		router := gin.New()
+		router.Handle(tc.method, tc.route, func(c *gin.Context) { got = auth.RouteScope(c) })
+		router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tc.method, tc.path, nil))
+		if got != tc.want {
+			t.Errorf("%s %s needs %q, want %q", tc.method, tc.path, got, tc.want)
+		}
+	}
+}
+
+func TestAPIKeyAuthentication(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		name      string
+		principal auth.Principal
+		err       error
+		method    string
+		status    int
+	}{
+		{"read scope reads", auth.Principal{UserID: 1, Scopes: []string{"orders:read"}}, nil, http.MethodGet, http.StatusOK},
+		{"read scope does not write", auth.Principal{UserID: 1, Scopes: []string{"orders:read"}}, nil, http.MethodPost, http.StatusForbidden},
+		{"write scope does not read", auth.Principal{UserID: 1, Scopes: []string{"orders:write"}}, nil, http.MethodGet, http.StatusForbidden},
+		{"other resources do not count", auth.Principal{UserID: 1, Scopes: []string{"tickets:read", "tickets:write"}}, nil, http.MethodGet, http.StatusForbidden},
+		{"all scopes", auth.Principal{UserID: 1, Scopes: []string{auth.ScopeAll}}, nil, http.MethodPost, http.StatusOK},
+		{"no scopes", auth.Principal{UserID: 1}, nil, http.MethodGet, http.StatusForbidden},
+		{"invalid key", auth.Principal{}, auth.ErrInvalidAPIKey, http.MethodGet, http.StatusUnauthorized},
+		{"database down", auth.Principal{}, problem.Unavailable(errors.New("connection refused")), http.MethodGet, http.StatusServiceUnavailable},
+		{"lookup failed", auth.Principal{}, errors.New("column does not exist"), http.MethodGet, http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var presented string
+			resolver := func(key string) (auth.Principal, error) {
+				presented = key
+				return tc.principal, tc.err
+			}
+			router := gin.New()
+			router.Use(middleware.ErrorMiddleware(), auth.Authenticate(nil, resolver))
+			router.Handle(tc.method, "/orders", func(c *gin.Context) {
+				principal, _ := auth.CurrentUser(c)
+				if principal.UserID != tc.principal.UserID {
+					t.Errorf("authenticated as %+v", principal)
+				}
+				c.Status(http.StatusOK)
+			})
+
+			r := httptest.NewRequest(tc.method, "/orders", nil)
+			r.Header.Set("Authorization", "ApiKey gk_secret")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			if w.Code != tc.status {
+				t.Errorf("answered %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if presented != "gk_secret" {
+				t.Errorf("resolved %q", presented)
+			}
+		})
+	}
+}
@@ -1,48 +1,181 @@
 package auth
 
 import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
 	"time"
 
-	"github.com/dgrijalva/jwt-go"
+	"go-gin-postgres/problem"
+
+	"github.com/golang-jwt/jwt/v5"
 	"github.com/gin-gonic/gin"
 )
 
-var jwtKey = []byte("my-secret-key")
+const (
+	// issuer is the iss claim of every token this API signs
+	issuer = "go-gin-postgres"
+	// audience is the aud claim access tokens are issued for
+	audience = "go-gin-postgres-api"
+	// clockSkew is the leeway allowed on exp, nbf and iat
+	clockSkew = 30 * time.Second
+)
+
+const (
+	// AccessTokenTTL is the lifetime of a JWT access token
+	AccessTokenTTL = 15 * time.Minute
+	// RefreshTokenTTL is the lifetime of a refresh token
+	RefreshTokenTTL = 30 * 24 * time.Hour
+)
+
+// allowedAlgorithms are the only signing algorithms tokens are accepted with
+var allowedAlgorithms = []string{"HS256", "RS256", "ES256"}
+
+// GenerateToken generates an access token for the given userID and roles, signed with the active key of ks
+func (ks *KeySet) GenerateToken(userID uint, roles []string) (string, error) {
+	return ks.sign(&Claims{Roles: roles}, userID, audience, AccessTokenTTL)
+}
+
+// sign fills in the registered claims and signs claims with the active key
+func (ks *KeySet) sign(claims *Claims, userID uint, aud string, ttl time.Duration) (string, error) {
+	now := time.Now()
+	claims.RegisteredClaims = jwt.RegisteredClaims{
+		ExpiresAt: jwt.NewNumericDate(now.Add(ttl)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+		Issuer:    issuer,
+		Audience:  jwt.ClaimStrings{aud},
+		Subject:   fmt.Sprint(userID),
+	}
+	token := jwt.NewWithClaims(ks.active.Method, claims)
+	token.Header["kid"] = ks.active.ID
+	return token.SignedString(ks.active.Sign)
+}
 
-// generateToken generates JWT token for the given userID
-func GenerateToken(userID uint) (string, error) {
-	claims := &jwt.StandardClaims{
-		ExpiresAt: time.Now().Add(time.Hour * 72).Unix(),
-		Issuer:    fmt.Sprint(userID),
+// GenerateRefreshToken returns a new opaque refresh token
+func GenerateRefreshToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
-	return token.SignedString(jwtKey)
+	return base64.RawURLEncoding.EncodeToString(b), nil
 }
 
-// Authenticate is a middleware to authenticate requests
-func Authenticate(c *gin.Context){
-	tokenString := c.GetHeader("Authorization")
+// HashToken returns the hex SHA-256 digest under which an opaque token is stored
+func HashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
 
-	if tokenString == "" {
-		c.JSON(401, gin.H{"error": "Authorization header is required"})
-		c.Abort()
-		return
+// ParseToken verifies an access token against the keys of ks and returns its principal
+func (ks *KeySet) ParseToken(tokenString string) (Principal, error) {
+	claims, userID, err := ks.parse(tokenString, audience)
+	if err != nil {
+		return Principal{}, err
 	}
+	return Principal{
+		UserID: userID,
+		Roles:  claims.Roles,
+		Scopes: claims.Scopes,
+	}, nil
+}
+
+// parse verifies a token issued for aud and returns its claims and user ID
+func (ks *KeySet) parse(tokenString, aud string) (*Claims, uint, error) {
+	parser := jwt.NewParser(
+		jwt.WithValidMethods(allowedAlgorithms),
+		jwt.WithIssuer(issuer),
+		jwt.WithAudience(aud),
+		jwt.WithLeeway(clockSkew),
+		jwt.WithExpirationRequired(),
+		jwt.WithIssuedAt(),
+	)
 
-	token, err := jwt.Parse(tokenString, func(token *jwt.Token) (interface{}, error) {
-		if _, ok := token.Method.(*jwt.SigningMethodHMAC); !ok{
+	claims := &Claims{}
+	_, err := parser.ParseWithClaims(tokenString, claims, func(token *jwt.Token) (interface{}, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ks.Lookup(kid)
+		if !ok {
+			return nil, fmt.Errorf("unknown key id %q", kid)
+		}
+		if token.Method.Alg() != key.Method.Alg() {
 			return nil, fmt.Errorf("unexpected signing method")
 		}
-		return jwtKey, nil
+		return key.Verify, nil
 	})
+	if err != nil {
+		return nil, 0, err
+	}
+
+	userID, err := strconv.ParseUint(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, 0, ErrInvalidSubject
+	}
+	return claims, uint(userID), nil
+}
+
+// Authenticate is a middleware to authenticate requests, with tokens verified against keys and API keys
+// looked up by apiKeys; a nil apiKeys refuses API keys.
+// It accepts "Authorization: Bearer <token>", "Authorization: ApiKey <key>" or "X-API-Key: <key>";
+// a bare token without a scheme is still accepted for older clients.
+func Authenticate(keys *KeySet, apiKeys APIKeyResolver) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scheme, credential := credentials(c)
+
+		var principal Principal
+		switch scheme {
+		case "":
+			unauthorized(c, CodeTokenMissing, "Authorization header is required")
+			return
+		case "apikey":
+			var ok bool
+			if principal, ok = authenticateAPIKey(c, apiKeys, credential); !ok {
+				return
+			}
+		case "bearer":
+			var err error
+			if principal, err = keys.ParseToken(credential); err != nil {
+				code, message := classifyTokenError(err)
+				unauthorized(c, code, message)
+				return
+			}
+		default:
+			unauthorized(c, CodeUnsupportedScheme, "Authorization scheme must be Bearer or ApiKey")
+			return
+		}
+		SetCurrentUser(c, principal)
 
-	if err != nil || !token.Valid{
-		c.JSON(401, gin.H{"error" : "Invalid or expired token"})
-		c.Abort()
-		return
+		c.Next()
 	}
-	
-	c.Next()
+}
 
+// credentials returns the lower-cased scheme and the credential presented with the request
+func credentials(c *gin.Context) (string, string) {
+	if key := c.GetHeader("X-API-Key"); key != "" {
+		return "apikey", key
+	}
+	header := strings.TrimSpace(c.GetHeader("Authorization"))
+	if header == "" {
+		return "", ""
+	}
+	scheme, credential, found := strings.Cut(header, " ")
+	if !found {
+		return "bearer", header
+	}
+	return strings.ToLower(scheme), strings.TrimSpace(credential)
+}
+
+// unauthorized aborts with a 401 and an RFC 6750 challenge
+func unauthorized(c *gin.Context, code, message string) {
+	challenge := `Bearer realm="go-gin-postgres"`
+	if code != CodeTokenMissing {
+		challenge += `, error="invalid_token"`
+	}
+	c.Header("WWW-Authenticate", challenge)
+	problem.Abort(c, problem.New(http.StatusUnauthorized, code, message))
 }
@@ -0,0 +1,179 @@
+package auth_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testSecret is the HS256 secret of the "hs" key of testKeys
+var testSecret = []byte("a test secret of at least 32 bytes")
+
+// testKeys returns a key set signing with the HS256 key "hs" and verifying an RS256 key "rs" too,
+// and the PEM of the public RS256 key
+func testKeys(t *testing.T) (*auth.KeySet, []byte) {
+	t.Helper()
+	hs, err := auth.ParseSigningKey("hs", "HS256", testSecret)
+	if err != nil {
+		t.Fatal(err)
+	}
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := auth.ParseSigningKey("rs", "RS256", pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(private)}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	public, err := x509.MarshalPKIXPublicKey(&private.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(hs, rs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return keys, pem.EncodeToMemory(&pem.Block{Type: "PUBLIC KEY", Bytes: public})
+}
+
+// forge signs claims with method and key under kid, the way an attacker or a misconfigured issuer would
+func forge(t *testing.T, method jwt.SigningMethod, kid string, key interface{}, claims jwt.Claims) string {
+	t.Helper()
+	token := jwt.NewWithClaims(method, claims)
+	token.Header["kid"] = kid
+	signed, err := token.SignedString(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return signed
+}
+
+// validClaims are the claims of an access token of user 7 issued now
+func validClaims() jwt.RegisteredClaims {
+	now := time.Now()
+	return jwt.RegisteredClaims{
+		Issuer:    "go-gin-postgres",
+		Audience:  jwt.ClaimStrings{"go-gin-postgres-api"},
+		Subject:   "7",
+		ExpiresAt: jwt.NewNumericDate(now.Add(time.Minute)),
+		NotBefore: jwt.NewNumericDate(now),
+		IssuedAt:  jwt.NewNumericDate(now),
+	}
+}
+
+func TestParseTokenIsStrict(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	keys, publicPEM := testKeys(t)
+	issued, err := keys.GenerateToken(7, []string{auth.RoleStaff})
+	if err != nil {
+		t.Fatal(err)
+	}
+	challenge, err := keys.GenerateChallengeToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	with := func(change func(claims *jwt.RegisteredClaims)) jwt.Claims {
+		claims := validClaims()
+		change(&claims)
+		return claims
+	}
+
+	for _, tc := range []struct {
+		name  string
+		token string
+		// code is the error code of the 401, "" when the token is accepted
+		code string
+	}{
+		{"issued", issued, ""},
+		{"forged with the secret", forge(t, jwt.SigningMethodHS256, "hs", testSecret, validClaims()), ""},
+		{"expired within the clock skew", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-10 * time.Second))
+		})), ""},
+		{"expired", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = jwt.NewNumericDate(time.Now().Add(-time.Minute))
+		})), auth.CodeTokenExpired},
+		{"no expiry", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.ExpiresAt = nil
+		})), auth.CodeTokenInvalidClaims},
+		{"not yet valid", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.NotBefore = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		})), auth.CodeTokenNotYetValid},
+		{"issued in the future", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.IssuedAt = jwt.NewNumericDate(time.Now().Add(time.Minute))
+		})), auth.CodeTokenNotYetValid},
+		{"another issuer", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.Issuer = "someone-else"
+		})), auth.CodeTokenInvalidIssuer},
+		{"another audience", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.Audience = jwt.ClaimStrings{"another-api"}
+		})), auth.CodeTokenInvalidAudience},
+		{"challenge token", challenge, auth.CodeTokenInvalidAudience},
+		{"subject not a user", forge(t, jwt.SigningMethodHS256, "hs", testSecret, with(func(c *jwt.RegisteredClaims) {
+			c.Subject = "admin"
+		})), auth.CodeTokenInvalidClaims},
+		{"another secret", forge(t, jwt.SigningMethodHS256, "hs", []byte("another secret of at least 32 bytes"), validClaims()), auth.CodeTokenInvalidSignature},
+		{"unknown key id", forge(t, jwt.SigningMethodHS256, "gone", testSecret, validClaims()), auth.CodeTokenUnknownKey},
+		{"public key as HMAC secret", forge(t, jwt.SigningMethodHS256, "rs", publicPEM, validClaims()), auth.CodeTokenUnknownKey},
+		{"HS384", forge(t, jwt.SigningMethodHS384, "hs", testSecret, validClaims()), auth.CodeTokenInvalidSignature},
+		{"unsigned", forge(t, jwt.SigningMethodNone, "hs", jwt.UnsafeAllowNoneSignatureType, validClaims()), auth.CodeTokenInvalidSignature},
+		{"malformed", "not.a.token", auth.CodeTokenMalformed},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			principal, err := keys.ParseToken(tc.token)
+			if tc.code == "" {
+				if err != nil || principal.UserID != 7 {
+					t.Fatalf("refused with %v", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatalf("accepted as %+v", principal)
+			}
+
+			router := gin.New()
+			router.Use(middleware.ErrorMiddleware(), auth.Authenticate(keys, nil))
+			router.GET("/", func(c *gin.Context) { c.Status(http.StatusOK) })
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Authorization", "Bearer "+tc.token)
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+			var body struct {
+				Code string `json:"code"`
+			}
+			_ = json.Unmarshal(w.Body.Bytes(), &body)
+			if w.Code != http.StatusUnauthorized || body.Code != tc.code {
+				t.Errorf("answered %d %q, want 401 %q", w.Code, body.Code, tc.code)
+			}
+		})
+	}
+}
+
+func TestChallengeTokens(t *testing.T) {
+	keys, _ := testKeys(t)
+	challenge, err := keys.GenerateChallengeToken(7)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if userID, err := keys.ParseChallengeToken(challenge); err != nil || userID != 7 {
+		t.Errorf("challenge token parsed as %d, %v", userID, err)
+	}
+	access, err := keys.GenerateToken(7, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := keys.ParseChallengeToken(access); err == nil {
+		t.Error("an access token passed as a challenge token")
+	}
+}
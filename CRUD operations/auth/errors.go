@@ -0,0 +1,50 @@
+package auth
+
+import (
+	"errors"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Error codes returned in the "code" field of 401 responses
+const (
+	CodeTokenMissing          = "token_missing"
+	CodeTokenMalformed        = "token_malformed"
+	CodeTokenExpired          = "token_expired"
+	CodeTokenNotYetValid      = "token_not_yet_valid"
+	CodeTokenInvalidAudience  = "token_invalid_audience"
+	CodeTokenInvalidIssuer    = "token_invalid_issuer"
+	CodeTokenInvalidSignature = "token_invalid_signature"
+	CodeTokenUnknownKey       = "token_unknown_key"
+	CodeTokenInvalidClaims    = "token_invalid_claims"
+	CodeTokenInvalid          = "token_invalid"
+	CodeAPIKeyInvalid         = "api_key_invalid"
+	CodeUnsupportedScheme     = "unsupported_scheme"
+	CodeInsufficientScope     = "insufficient_scope"
+)
+
+// ErrInvalidSubject is returned when a token's sub claim is not a user ID
+var ErrInvalidSubject = errors.New("token subject is not a user id")
+
+// classifyTokenError maps a token validation error to its error code and message
+func classifyTokenError(err error) (string, string) {
+	switch {
+	case errors.Is(err, jwt.ErrTokenMalformed):
+		return CodeTokenMalformed, "token is malformed"
+	case errors.Is(err, jwt.ErrTokenExpired):
+		return CodeTokenExpired, "token has expired"
+	case errors.Is(err, jwt.ErrTokenNotValidYet), errors.Is(err, jwt.ErrTokenUsedBeforeIssued):
+		return CodeTokenNotYetValid, "token is not valid yet"
+	case errors.Is(err, jwt.ErrTokenInvalidAudience):
+		return CodeTokenInvalidAudience, "token was issued for a different audience"
+	case errors.Is(err, jwt.ErrTokenInvalidIssuer):
+		return CodeTokenInvalidIssuer, "token was issued by an unknown issuer"
+	case errors.Is(err, jwt.ErrTokenSignatureInvalid):
+		return CodeTokenInvalidSignature, "token signature is invalid"
+	case errors.Is(err, jwt.ErrTokenUnverifiable):
+		return CodeTokenUnknownKey, "token was signed with an unknown key"
+	case errors.Is(err, jwt.ErrTokenRequiredClaimMissing), errors.Is(err, ErrInvalidSubject):
+		return CodeTokenInvalidClaims, "token is missing required claims"
+	}
+	return CodeTokenInvalid, "token is invalid"
+}
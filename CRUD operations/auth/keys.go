@@ -0,0 +1,202 @@
+package auth
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"go-gin-postgres/config"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningKey is one key of a KeySet, identified in token headers by its kid
+type SigningKey struct {
+	ID     string
+	Method jwt.SigningMethod
+	// Sign is the HMAC secret or private key; nil for verification-only keys
+	Sign interface{}
+	// Verify is the HMAC secret or public key
+	Verify interface{}
+}
+
+// KeySet holds the key tokens are signed with plus every key still accepted during a rotation
+type KeySet struct {
+	active *SigningKey
+	keys   map[string]*SigningKey
+}
+
+// JWK is a public key in RFC 7517 JSON Web Key form
+type JWK struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// JWKSet is the document served at /.well-known/jwks.json
+type JWKSet struct {
+	Keys []JWK `json:"keys"`
+}
+
+// NewKeySet returns a key set signing with active and also accepting the verification-only keys
+func NewKeySet(active *SigningKey, verifyOnly ...*SigningKey) (*KeySet, error) {
+	if active == nil || active.Sign == nil {
+		return nil, fmt.Errorf("active key must be able to sign")
+	}
+	ks := &KeySet{active: active, keys: map[string]*SigningKey{active.ID: active}}
+	for _, key := range verifyOnly {
+		if _, exists := ks.keys[key.ID]; exists {
+			return nil, fmt.Errorf("duplicate key id %q", key.ID)
+		}
+		ks.keys[key.ID] = key
+	}
+	return ks, nil
+}
+
+// Lookup returns the key with the given kid
+func (ks *KeySet) Lookup(kid string) (*SigningKey, bool) {
+	key, ok := ks.keys[kid]
+	return key, ok
+}
+
+// JWKS returns the public keys of the set; HMAC secrets are never published
+func (ks *KeySet) JWKS() JWKSet {
+	set := JWKSet{Keys: []JWK{}}
+	for _, key := range ks.keys {
+		switch pub := key.Verify.(type) {
+		case *rsa.PublicKey:
+			set.Keys = append(set.Keys, JWK{
+				Kty: "RSA",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				N:   base64.RawURLEncoding.EncodeToString(pub.N.Bytes()),
+				E:   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(pub.E)).Bytes()),
+			})
+		case *ecdsa.PublicKey:
+			size := (pub.Curve.Params().BitSize + 7) / 8
+			set.Keys = append(set.Keys, JWK{
+				Kty: "EC",
+				Kid: key.ID,
+				Alg: key.Method.Alg(),
+				Use: "sig",
+				Crv: pub.Curve.Params().Name,
+				X:   base64.RawURLEncoding.EncodeToString(pub.X.FillBytes(make([]byte, size))),
+				Y:   base64.RawURLEncoding.EncodeToString(pub.Y.FillBytes(make([]byte, size))),
+			})
+		}
+	}
+	return set
+}
+
+// ParseSigningKey builds a signing key for alg from an HMAC secret or a PEM private key
+func ParseSigningKey(kid, alg string, material []byte) (*SigningKey, error) {
+	switch alg {
+	case "HS256":
+		if len(material) < 32 {
+			return nil, fmt.Errorf("HS256 secret must be at least 32 bytes")
+		}
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodHS256, Sign: material, Verify: material}, nil
+	case "RS256":
+		private, err := jwt.ParseRSAPrivateKeyFromPEM(material)
+		if err != nil {
+			return nil, err
+		}
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Sign: private, Verify: &private.PublicKey}, nil
+	case "ES256":
+		private, err := jwt.ParseECPrivateKeyFromPEM(material)
+		if err != nil {
+			return nil, err
+		}
+		if private.Curve != elliptic.P256() {
+			return nil, fmt.Errorf("ES256 requires a P-256 key")
+		}
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodES256, Sign: private, Verify: &private.PublicKey}, nil
+	}
+	return nil, fmt.Errorf("unsupported signing algorithm %q", alg)
+}
+
+// ParseVerifyKey builds a verification-only key from a PEM public key, or an HS256 secret when material is not PEM
+func ParseVerifyKey(kid string, material []byte) (*SigningKey, error) {
+	if !strings.Contains(string(material), "-----BEGIN") {
+		key, err := ParseSigningKey(kid, "HS256", material)
+		if err != nil {
+			return nil, err
+		}
+		key.Sign = nil
+		return key, nil
+	}
+	if public, err := jwt.ParseRSAPublicKeyFromPEM(material); err == nil {
+		return &SigningKey{ID: kid, Method: jwt.SigningMethodRS256, Verify: public}, nil
+	}
+	public, err := jwt.ParseECPublicKeyFromPEM(material)
+	if err != nil {
+		return nil, fmt.Errorf("key %q is neither an RSA nor an EC public key", kid)
+	}
+	if public.Curve != elliptic.P256() {
+		return nil, fmt.Errorf("key %q: ES256 requires a P-256 key", kid)
+	}
+	return &SigningKey{ID: kid, Method: jwt.SigningMethodES256, Verify: public}, nil
+}
+
+// LoadKeys builds the key set from the JWT settings: the active key for cfg.Algorithm,
+// plus the keys in cfg.PreviousKeys, still accepted for verification
+func LoadKeys(cfg config.JWT) (*KeySet, error) {
+	var material []byte
+	var err error
+	switch {
+	case cfg.Algorithm == "HS256" && cfg.Secret != "":
+		material = []byte(cfg.Secret)
+	case cfg.Algorithm != "HS256" && cfg.PrivateKeyFile != "":
+		material, err = os.ReadFile(cfg.PrivateKeyFile)
+	default:
+		return nil, fmt.Errorf("no signing key configured for %s", cfg.Algorithm)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	active, err := ParseSigningKey(cfg.KeyID, cfg.Algorithm, material)
+	if err != nil {
+		return nil, err
+	}
+
+	var previous []*SigningKey
+	for _, entry := range cfg.PreviousKeys {
+		id, path, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		if !ok {
+			return nil, fmt.Errorf("previous key %q must be kid=path", entry)
+		}
+		material, err := readSecretFile(path)
+		if err != nil {
+			return nil, err
+		}
+		key, err := ParseVerifyKey(id, material)
+		if err != nil {
+			return nil, err
+		}
+		previous = append(previous, key)
+	}
+
+	return NewKeySet(active, previous...)
+}
+
+// readSecretFile reads a secret from a file, trimming the trailing newline editors add
+func readSecretFile(path string) ([]byte, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	return []byte(strings.TrimRight(string(content), "\r\n")), nil
+}
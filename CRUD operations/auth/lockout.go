@@ -0,0 +1,179 @@
+package auth
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// Attempt is the failed-login state of one account or client IP
+type Attempt struct {
+	Failures    int
+	LastFailure time.Time
+}
+
+// AttemptStore keeps failed-login counters keyed by "account:<email>" or "ip:<address>"
+type AttemptStore interface {
+	// Get returns the attempt state of key, zero if there is none
+	Get(key string) (Attempt, error)
+	// RecordFailure increments the failure counter of key and returns the new state
+	RecordFailure(key string, at time.Time) (Attempt, error)
+	// Reset clears the counter of key
+	Reset(key string) error
+}
+
+// LockoutPolicy configures backoff and lockout for one kind of key
+type LockoutPolicy struct {
+	// MaxFailures is the number of failures after which the key is locked out
+	MaxFailures int
+	// BaseDelay is the wait after the first failure; it doubles with every further failure
+	BaseDelay time.Duration
+	// Lockout is how long a key stays locked once MaxFailures is reached
+	Lockout time.Duration
+	// ResetAfter forgets failures older than this
+	ResetAfter time.Duration
+}
+
+// blockedUntil returns when a key in state a may try again
+func (p LockoutPolicy) blockedUntil(a Attempt) time.Time {
+	if a.Failures == 0 {
+		return time.Time{}
+	}
+	if a.Failures >= p.MaxFailures {
+		return a.LastFailure.Add(p.Lockout)
+	}
+	delay := p.BaseDelay << (a.Failures - 1)
+	if delay > p.Lockout || delay <= 0 {
+		delay = p.Lockout
+	}
+	return a.LastFailure.Add(delay)
+}
+
+// LoginGuard applies per-account and per-IP lockout policies to login attempts
+type LoginGuard struct {
+	store   AttemptStore
+	Account LockoutPolicy
+	IP      LockoutPolicy
+}
+
+// NewLoginGuard returns a guard with default policies: accounts lock after 5 failures, IPs after 20
+func NewLoginGuard(store AttemptStore) *LoginGuard {
+	return &LoginGuard{
+		store: store,
+		Account: LockoutPolicy{
+			MaxFailures: 5,
+			BaseDelay:   time.Second,
+			Lockout:     15 * time.Minute,
+			ResetAfter:  24 * time.Hour,
+		},
+		IP: LockoutPolicy{
+			MaxFailures: 20,
+			BaseDelay:   100 * time.Millisecond,
+			Lockout:     15 * time.Minute,
+			ResetAfter:  time.Hour,
+		},
+	}
+}
+
+// lockoutCheck pairs a counter key with the policy that applies to it
+type lockoutCheck struct {
+	key    string
+	policy LockoutPolicy
+}
+
+func (g *LoginGuard) checks(email, ip string) []lockoutCheck {
+	return []lockoutCheck{
+		{accountKey(email), g.Account},
+		{ipKey(ip), g.IP},
+	}
+}
+
+// Allow returns how long the caller must wait before trying email from ip again, zero if it may try now
+func (g *LoginGuard) Allow(email, ip string) (time.Duration, error) {
+	var wait time.Duration
+	for _, check := range g.checks(email, ip) {
+		attempt, err := g.store.Get(check.key)
+		if err != nil {
+			return 0, err
+		}
+		if time.Since(attempt.LastFailure) > check.policy.ResetAfter {
+			continue
+		}
+		if remaining := time.Until(check.policy.blockedUntil(attempt)); remaining > wait {
+			wait = remaining
+		}
+	}
+	return wait, nil
+}
+
+// Failure records a failed attempt against both the account and the IP
+func (g *LoginGuard) Failure(email, ip string) error {
+	now := time.Now()
+	for _, check := range g.checks(email, ip) {
+		attempt, err := g.store.Get(check.key)
+		if err != nil {
+			return err
+		}
+		if attempt.Failures > 0 && now.Sub(attempt.LastFailure) > check.policy.ResetAfter {
+			if err := g.store.Reset(check.key); err != nil {
+				return err
+			}
+		}
+		if _, err := g.store.RecordFailure(check.key, now); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Success clears the account counter; the IP counter is kept so one good login cannot hide a spray
+func (g *LoginGuard) Success(email string) error {
+	return g.store.Reset(accountKey(email))
+}
+
+// Unlock clears the account counter of email
+func (g *LoginGuard) Unlock(email string) error {
+	return g.store.Reset(accountKey(email))
+}
+
+func accountKey(email string) string {
+	return "account:" + strings.ToLower(strings.TrimSpace(email))
+}
+
+func ipKey(ip string) string {
+	return "ip:" + ip
+}
+
+// MemoryAttemptStore is an in-process AttemptStore for tests and single-instance setups
+type MemoryAttemptStore struct {
+	mu       sync.Mutex
+	attempts map[string]Attempt
+}
+
+// NewMemoryAttemptStore returns an empty in-memory store
+func NewMemoryAttemptStore() *MemoryAttemptStore {
+	return &MemoryAttemptStore{attempts: map[string]Attempt{}}
+}
+
+func (s *MemoryAttemptStore) Get(key string) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.attempts[key], nil
+}
+
+func (s *MemoryAttemptStore) RecordFailure(key string, at time.Time) (Attempt, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	attempt := s.attempts[key]
+	attempt.Failures++
+	attempt.LastFailure = at
+	s.attempts[key] = attempt
+	return attempt, nil
+}
+
+func (s *MemoryAttemptStore) Reset(key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.attempts, key)
+	return nil
+}
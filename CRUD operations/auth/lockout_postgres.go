@@ -0,0 +1,46 @@
+package auth
+
+import (
+	"time"
+
+	"go-gin-postgres/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// PostgresAttemptStore keeps failed-login counters in the login_attempts table so they are shared between instances
+type PostgresAttemptStore struct {
+	db *gorm.DB
+}
+
+// NewPostgresAttemptStore returns a store backed by db
+func NewPostgresAttemptStore(db *gorm.DB) *PostgresAttemptStore {
+	return &PostgresAttemptStore{db: db}
+}
+
+func (s *PostgresAttemptStore) Get(key string) (Attempt, error) {
+	var record models.LoginAttempt
+	err := s.db.Where("subject = ?", key).First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return Attempt{}, nil
+	}
+	if err != nil {
+		return Attempt{}, err
+	}
+	return Attempt{Failures: record.Failures, LastFailure: record.LastFailureAt}, nil
+}
+
+func (s *PostgresAttemptStore) RecordFailure(key string, at time.Time) (Attempt, error) {
+	var attempt Attempt
+	row := s.db.Raw(`INSERT INTO login_attempts (subject, failures, last_failure_at) VALUES (?, 1, ?)
+		ON CONFLICT (subject) DO UPDATE SET failures = login_attempts.failures + 1, last_failure_at = EXCLUDED.last_failure_at
+		RETURNING failures, last_failure_at`, key, at).Row()
+	if err := row.Scan(&attempt.Failures, &attempt.LastFailure); err != nil {
+		return Attempt{}, err
+	}
+	return attempt, nil
+}
+
+func (s *PostgresAttemptStore) Reset(key string) error {
+	return s.db.Where("subject = ?", key).Delete(&models.LoginAttempt{}).Error
+}
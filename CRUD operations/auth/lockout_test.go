@@ -0,0 +1,96 @@
+package auth_test
+
+import (
+	"testing"
+	"time"
+
+	"go-gin-postgres/auth"
+)
+
+// testGuard returns a guard on an in-memory store that locks accounts after 3 failures, backing off
+// one minute and doubling before that, and IPs after 5
+func testGuard() (*auth.LoginGuard, *auth.MemoryAttemptStore) {
+	store := auth.NewMemoryAttemptStore()
+	guard := auth.NewLoginGuard(store)
+	guard.Account = auth.LockoutPolicy{MaxFailures: 3, BaseDelay: time.Minute, Lockout: 10 * time.Minute, ResetAfter: time.Hour}
+	guard.IP = auth.LockoutPolicy{MaxFailures: 5, BaseDelay: time.Nanosecond, Lockout: 30 * time.Minute, ResetAfter: time.Hour}
+	return guard, store
+}
+
+func TestLoginGuard(t *testing.T) {
+	const ip = "192.0.2.1"
+	for _, tc := range []struct {
+		name string
+		// run makes the attempts on the guard
+		run func(t *testing.T, guard *auth.LoginGuard, store *auth.MemoryAttemptStore)
+		// wait is how long ada@example.com must wait afterwards, within a second
+		wait time.Duration
+	}{
+		{"no failures", func(*testing.T, *auth.LoginGuard, *auth.MemoryAttemptStore) {}, 0},
+		{"first failure backs off", fail(1, "ada@example.com"), time.Minute},
+		{"backoff doubles", fail(2, "ada@example.com"), 2 * time.Minute},
+		{"locks out at the threshold", fail(3, "ada@example.com"), 10 * time.Minute},
+		{"stays locked past the threshold", fail(4, "ada@example.com"), 10 * time.Minute},
+		{"email case does not matter", fail(3, " ADA@example.com"), 10 * time.Minute},
+		{"other accounts do not count", fail(2, "bob@example.com"), 0},
+		{"success resets the account", then(fail(3, "ada@example.com"), func(t *testing.T, guard *auth.LoginGuard, _ *auth.MemoryAttemptStore) {
+			if err := guard.Success("ada@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}), 0},
+		{"admin unlock resets the account", then(fail(3, "ada@example.com"), func(t *testing.T, guard *auth.LoginGuard, _ *auth.MemoryAttemptStore) {
+			if err := guard.Unlock("ada@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}), 0},
+		{"the IP locks out across accounts", fail(5, "a@example.com", "b@example.com", "c@example.com", "d@example.com", "e@example.com"), 30 * time.Minute},
+		{"success keeps the IP counter", then(fail(5, "ada@example.com"), func(t *testing.T, guard *auth.LoginGuard, _ *auth.MemoryAttemptStore) {
+			if err := guard.Success("ada@example.com"); err != nil {
+				t.Fatal(err)
+			}
+		}), 30 * time.Minute},
+		{"old failures are forgotten", func(t *testing.T, guard *auth.LoginGuard, store *auth.MemoryAttemptStore) {
+			for i := 0; i < 3; i++ {
+				store.RecordFailure("account:ada@example.com", time.Now().Add(-2*time.Hour))
+			}
+		}, 0},
+		{"a failure after old ones starts over", then(func(t *testing.T, guard *auth.LoginGuard, store *auth.MemoryAttemptStore) {
+			for i := 0; i < 3; i++ {
+				store.RecordFailure("account:ada@example.com", time.Now().Add(-2*time.Hour))
+			}
+		}, fail(1, "ada@example.com")), time.Minute},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			guard, store := testGuard()
+			tc.run(t, guard, store)
+
+			wait, err := guard.Allow("ada@example.com", ip)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if wait > tc.wait || wait < tc.wait-time.Second {
+				t.Errorf("must wait %v, want %v", wait, tc.wait)
+			}
+		})
+	}
+}
+
+// fail records n failed logins from 192.0.2.1, cycling through emails
+func fail(n int, emails ...string) func(*testing.T, *auth.LoginGuard, *auth.MemoryAttemptStore) {
+	return func(t *testing.T, guard *auth.LoginGuard, _ *auth.MemoryAttemptStore) {
+		for i := 0; i < n; i++ {
+			if err := guard.Failure(emails[i%len(emails)], "192.0.2.1"); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+}
+
+// then runs steps one after another
+func then(steps ...func(*testing.T, *auth.LoginGuard, *auth.MemoryAttemptStore)) func(*testing.T, *auth.LoginGuard, *auth.MemoryAttemptStore) {
+	return func(t *testing.T, guard *auth.LoginGuard, store *auth.MemoryAttemptStore) {
+		for _, step := range steps {
+			step(t, guard, store)
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package auth
+
+import (
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// Roles a user can hold
+const (
+	RoleAdmin    = "admin"
+	RoleStaff    = "staff"
+	RoleCustomer = "customer"
+)
+
+// principalKey is the gin.Context key holding the authenticated Principal
+const principalKey = "principal"
+
+// Claims are the JWT claims issued by GenerateToken
+type Claims struct {
+	Roles  []string `json:"roles,omitempty"`
+	Scopes []string `json:"scopes,omitempty"`
+	jwt.RegisteredClaims
+}
+
+// Principal is the authenticated caller of a request
+type Principal struct {
+	UserID uint
+	Roles  []string
+	Scopes []string
+	// APIKeyID is set when the caller authenticated with an API key instead of a token
+	APIKeyID uint
+}
+
+// HasRole reports whether the principal holds role
+func (p Principal) HasRole(role string) bool {
+	for _, r := range p.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// HasAnyRole reports whether the principal holds at least one of roles
+func (p Principal) HasAnyRole(roles ...string) bool {
+	for _, role := range roles {
+		if p.HasRole(role) {
+			return true
+		}
+	}
+	return false
+}
+
+// HasScope reports whether the principal was granted scope
+func (p Principal) HasScope(scope string) bool {
+	for _, s := range p.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// IsAdmin reports whether the principal holds the admin role
+func (p Principal) IsAdmin() bool {
+	return p.HasRole(RoleAdmin)
+}
+
+// Require is a middleware that only lets through principals holding one of roles
+func Require(roles ...string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, ok := CurrentUser(c)
+		if !ok || !principal.HasAnyRole(roles...) {
+			problem.Abort(c, problem.Forbidden("your role does not allow this request"))
+			return
+		}
+		c.Next()
+	}
+}
+
+// SetCurrentUser attaches the principal to the request context
+func SetCurrentUser(c *gin.Context, p Principal) {
+	c.Set(principalKey, p)
+}
+
+// CurrentUser returns the principal attached by Authenticate
+func CurrentUser(c *gin.Context) (Principal, bool) {
+	value, ok := c.Get(principalKey)
+	if !ok {
+		return Principal{}, false
+	}
+	p, ok := value.(Principal)
+	return p, ok
+}
@@ -0,0 +1,98 @@
+package auth_test
+
+import (
+	"encoding/base32"
+	"net/url"
+	"regexp"
+	"testing"
+	"time"
+
+	"go-gin-postgres/auth"
+)
+
+// rfc6238Secret is the SHA-1 secret of the RFC 6238 test vectors, "12345678901234567890", in base32
+const rfc6238Secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestValidateTOTP(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		secret string
+		code   string
+		at     int64
+		// step is the time step matched, 0 when the code is refused
+		step int64
+	}{
+		// The last six digits of the RFC 6238 appendix B vectors
+		{"RFC 6238 at 59", rfc6238Secret, "287082", 59, 1},
+		{"RFC 6238 at 1111111109", rfc6238Secret, "081804", 1111111109, 37037036},
+		{"RFC 6238 at 1111111111", rfc6238Secret, "050471", 1111111111, 37037037},
+		{"RFC 6238 at 1234567890", rfc6238Secret, "005924", 1234567890, 41152263},
+		{"RFC 6238 at 2000000000", rfc6238Secret, "279037", 2000000000, 66666666},
+		{"RFC 6238 at 20000000000", rfc6238Secret, "353130", 20000000000, 666666666},
+		{"code of the previous step", rfc6238Secret, "005924", 1234567890 + 30, 41152263},
+		{"code of the next step", rfc6238Secret, "005924", 1234567890 - 30, 41152263},
+		{"code of two steps back", rfc6238Secret, "005924", 1234567890 + 60, 0},
+		{"code of two steps ahead", rfc6238Secret, "005924", 1234567890 - 60, 0},
+		{"wrong code", rfc6238Secret, "005925", 1234567890, 0},
+		{"eight digits", rfc6238Secret, "89005924", 1234567890, 0},
+		{"short code", rfc6238Secret, "05924", 1234567890, 0},
+		{"empty code", rfc6238Secret, "", 1234567890, 0},
+		{"lower-case secret", " gezdgnbvgy3tqojqgezdgnbvgy3tqojq ", "005924", 1234567890, 41152263},
+		{"invalid secret", "not base32!", "005924", 1234567890, 0},
+		{"another secret", "JBSWY3DPEHPK3PXP", "005924", 1234567890, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			step, ok := auth.ValidateTOTP(tc.secret, tc.code, time.Unix(tc.at, 0))
+			if ok != (tc.step != 0) || step != tc.step {
+				t.Errorf("matched step %d, %v, want %d", step, ok, tc.step)
+			}
+		})
+	}
+}
+
+func TestGenerateTOTPSecret(t *testing.T) {
+	secret, err := auth.GenerateTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil || len(key) != 20 {
+		t.Errorf("secret %q decodes to %d bytes, %v; want 20", secret, len(key), err)
+	}
+	if other, _ := auth.GenerateTOTPSecret(); other == secret {
+		t.Error("two secrets are the same")
+	}
+}
+
+func TestTOTPURI(t *testing.T) {
+	uri, err := url.Parse(auth.TOTPURI(rfc6238Secret, "ada@example.com"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := uri.Query()
+	if uri.Scheme != "otpauth" || uri.Host != "totp" || uri.Path != "/go-gin-postgres:ada@example.com" {
+		t.Errorf("URI is %s", uri)
+	}
+	for name, want := range map[string]string{
+		"secret": rfc6238Secret, "issuer": "go-gin-postgres", "algorithm": "SHA1", "digits": "6", "period": "30",
+	} {
+		if got := query.Get(name); got != want {
+			t.Errorf("%s is %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestGenerateRecoveryCode(t *testing.T) {
+	format := regexp.MustCompile(`^[A-Z2-7]{5}-[A-Z2-7]{5}$`)
+	seen := map[string]bool{}
+	for i := 0; i < 100; i++ {
+		code, err := auth.GenerateRecoveryCode()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !format.MatchString(code) || seen[code] {
+			t.Fatalf("generated %q", code)
+		}
+		seen[code] = true
+	}
+}
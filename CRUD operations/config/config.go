@@ -0,0 +1,520 @@
+// Package config loads the application settings from defaults, an optional YAML or TOML file,
+// environment variables and command-line flags, each overriding the one before
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// Config holds every setting of the API
+type Config struct {
+	Server   Server
+	Database Database
+	JWT      JWT
+	Mail     Mail
+	Log      Log
+}
+
+// Server configures the HTTP listener
+type Server struct {
+	// Addr is the address the router listens on, e.g. ":8080"
+	Addr string
+	// BaseURL is the public URL of the API, used in links sent by email
+	BaseURL string
+	// TrustedProxies are the IPs or CIDRs of reverse proxies whose X-Forwarded-For is believed.
+	// Empty trusts none, and the client IP is the address of the connection
+	TrustedProxies []string
+}
+
+// Database configures the Postgres connection
+type Database struct {
+	Host     string
+	Port     int
+	User     string
+	Password string
+	Name     string
+	SSLMode  string
+	// LogQueries logs every SQL statement
+	LogQueries bool
+	// MaxOpenConns caps the connections in the pool; 0 means no limit
+	MaxOpenConns int
+	// MaxIdleConns is how many unused connections the pool keeps
+	MaxIdleConns int
+	// ConnMaxLifetime closes connections older than this, so that they are spread again after a failover
+	ConnMaxLifetime time.Duration
+	// ConnMaxIdleTime closes connections unused for this long
+	ConnMaxIdleTime time.Duration
+	// ConnectAttempts is how often startup tries to reach Postgres before the server starts degraded
+	ConnectAttempts int
+	// ConnectBackoff is the wait after the first failed attempt; it doubles up to ConnectMaxBackoff
+	ConnectBackoff    time.Duration
+	ConnectMaxBackoff time.Duration
+	// LegacyTimeZone is the zone that times stored without one were written in. Migration 15 reads them in it
+	// when it turns them into timestamptz
+	LegacyTimeZone string
+}
+
+// JWT configures the token signing keys
+type JWT struct {
+	// Algorithm is HS256, RS256 or ES256
+	Algorithm string
+	// KeyID is the kid of the active key
+	KeyID string
+	// Secret is the HS256 secret
+	Secret string
+	// PrivateKeyFile is the PEM private key for RS256 and ES256
+	PrivateKeyFile string
+	// PreviousKeys are kid=path pairs of keys still accepted for verification
+	PreviousKeys []string
+}
+
+// Mail configures outbound email
+type Mail struct {
+	// Driver is "file", which writes messages into Dir, or "smtp"
+	Driver       string
+	From         string
+	Dir          string
+	SMTPHost     string
+	SMTPPort     int
+	SMTPUsername string
+	SMTPPassword string
+}
+
+// Log configures the application logger
+type Log struct {
+	// Level is a logrus level: debug, info, warn, error
+	Level string
+	// Format is "text" or "json"
+	Format string
+}
+
+// Default returns the settings used when nothing overrides them, suitable for local development
+func Default() *Config {
+	return &Config{
+		Server: Server{Addr: ":8080", BaseURL: "http://localhost:8080"},
+		Database: Database{
+			Host:    "localhost",
+			Port:    5432,
+			User:    "postgres",
+			Name:    "myapi",
+			SSLMode: "disable",
+
+			MaxOpenConns:      25,
+			MaxIdleConns:      10,
+			ConnMaxLifetime:   30 * time.Minute,
+			ConnMaxIdleTime:   5 * time.Minute,
+			ConnectAttempts:   5,
+			ConnectBackoff:    time.Second,
+			ConnectMaxBackoff: 30 * time.Second,
+			LegacyTimeZone:    "UTC",
+		},
+		JWT:  JWT{Algorithm: "HS256", KeyID: "default"},
+		Mail: Mail{Driver: "file", From: "no-reply@localhost", Dir: "mail", SMTPPort: 587},
+		Log:  Log{Level: "info", Format: "text"},
+	}
+}
+
+// setting ties one field of Config to its config file key, environment variable and flag
+type setting struct {
+	key   string
+	env   string
+	flag  string
+	usage string
+	// secret settings can also be read from a file named by <env>_FILE, <key>_file or -<flag>-file,
+	// so that they can come from Docker or Kubernetes secrets
+	secret bool
+	set    func(value string) error
+}
+
+func (c *Config) settings() []setting {
+	return []setting{
+		{key: "server.addr", env: "APP_ADDR", flag: "addr", usage: "address to listen on", set: stringVar(&c.Server.Addr)},
+		{key: "server.base_url", env: "APP_BASE_URL", flag: "base-url", usage: "public URL of the API", set: stringVar(&c.Server.BaseURL)},
+		{key: "server.trusted_proxies", env: "APP_TRUSTED_PROXIES", flag: "trusted-proxies", usage: "comma-separated IPs or CIDRs of reverse proxies", set: listVar(&c.Server.TrustedProxies)},
+
+		{key: "database.host", env: "DB_HOST", flag: "db-host", usage: "Postgres host", set: stringVar(&c.Database.Host)},
+		{key: "database.port", env: "DB_PORT", flag: "db-port", usage: "Postgres port", set: intVar(&c.Database.Port)},
+		{key: "database.user", env: "DB_USER", flag: "db-user", usage: "Postgres user", set: stringVar(&c.Database.User)},
+		{key: "database.password", env: "DB_PASSWORD", flag: "db-password", usage: "Postgres password", secret: true, set: stringVar(&c.Database.Password)},
+		{key: "database.name", env: "DB_NAME", flag: "db-name", usage: "Postgres database", set: stringVar(&c.Database.Name)},
+		{key: "database.sslmode", env: "DB_SSLMODE", flag: "db-sslmode", usage: "Postgres sslmode", set: stringVar(&c.Database.SSLMode)},
+		{key: "database.log_queries", env: "DB_LOG_QUERIES", flag: "db-log-queries", usage: "log every SQL statement", set: boolVar(&c.Database.LogQueries)},
+		{key: "database.max_open_conns", env: "DB_MAX_OPEN_CONNS", flag: "db-max-open-conns", usage: "most open connections, 0 for no limit", set: intVar(&c.Database.MaxOpenConns)},
+		{key: "database.max_idle_conns", env: "DB_MAX_IDLE_CONNS", flag: "db-max-idle-conns", usage: "most idle connections kept", set: intVar(&c.Database.MaxIdleConns)},
+		{key: "database.conn_max_lifetime", env: "DB_CONN_MAX_LIFETIME", flag: "db-conn-max-lifetime", usage: "close connections older than this", set: durationVar(&c.Database.ConnMaxLifetime)},
+		{key: "database.conn_max_idle_time", env: "DB_CONN_MAX_IDLE_TIME", flag: "db-conn-max-idle-time", usage: "close connections idle for this long", set: durationVar(&c.Database.ConnMaxIdleTime)},
+		{key: "database.connect_attempts", env: "DB_CONNECT_ATTEMPTS", flag: "db-connect-attempts", usage: "connection attempts before starting degraded", set: intVar(&c.Database.ConnectAttempts)},
+		{key: "database.connect_backoff", env: "DB_CONNECT_BACKOFF", flag: "db-connect-backoff", usage: "wait after the first failed connection attempt", set: durationVar(&c.Database.ConnectBackoff)},
+		{key: "database.connect_max_backoff", env: "DB_CONNECT_MAX_BACKOFF", flag: "db-connect-max-backoff", usage: "longest wait between connection attempts", set: durationVar(&c.Database.ConnectMaxBackoff)},
+		{key: "database.legacy_time_zone", env: "DB_LEGACY_TIME_ZONE", flag: "db-legacy-time-zone", usage: "time zone of times stored without one, read by migration 15", set: stringVar(&c.Database.LegacyTimeZone)},
+
+		{key: "jwt.algorithm", env: "JWT_ALGORITHM", flag: "jwt-algorithm", usage: "HS256, RS256 or ES256", set: stringVar(&c.JWT.Algorithm)},
+		{key: "jwt.key_id", env: "JWT_KEY_ID", flag: "jwt-key-id", usage: "kid of the active signing key", set: stringVar(&c.JWT.KeyID)},
+		{key: "jwt.secret", env: "JWT_SECRET", flag: "jwt-secret", usage: "HS256 secret", secret: true, set: stringVar(&c.JWT.Secret)},
+		{key: "jwt.private_key_file", env: "JWT_PRIVATE_KEY_FILE", flag: "jwt-private-key-file", usage: "PEM private key for RS256 and ES256", set: stringVar(&c.JWT.PrivateKeyFile)},
+		{key: "jwt.previous_keys", env: "JWT_PREVIOUS_KEYS", flag: "jwt-previous-keys", usage: "comma-separated kid=path pairs of keys still accepted", set: listVar(&c.JWT.PreviousKeys)},
+
+		{key: "mail.driver", env: "MAIL_DRIVER", flag: "mail-driver", usage: "file or smtp", set: stringVar(&c.Mail.Driver)},
+		{key: "mail.from", env: "MAIL_FROM", flag: "mail-from", usage: "sender address", set: stringVar(&c.Mail.From)},
+		{key: "mail.dir", env: "MAIL_DIR", flag: "mail-dir", usage: "directory of the file mail driver", set: stringVar(&c.Mail.Dir)},
+		{key: "mail.smtp_host", env: "SMTP_HOST", flag: "smtp-host", usage: "SMTP host", set: stringVar(&c.Mail.SMTPHost)},
+		{key: "mail.smtp_port", env: "SMTP_PORT", flag: "smtp-port", usage: "SMTP port", set: intVar(&c.Mail.SMTPPort)},
+		{key: "mail.smtp_username", env: "SMTP_USERNAME", flag: "smtp-username", usage: "SMTP user", set: stringVar(&c.Mail.SMTPUsername)},
+		{key: "mail.smtp_password", env: "SMTP_PASSWORD", flag: "smtp-password", usage: "SMTP password", secret: true, set: stringVar(&c.Mail.SMTPPassword)},
+
+		{key: "log.level", env: "LOG_LEVEL", flag: "log-level", usage: "debug, info, warn or error", set: stringVar(&c.Log.Level)},
+		{key: "log.format", env: "LOG_FORMAT", flag: "log-format", usage: "text or json", set: stringVar(&c.Log.Format)},
+	}
+}
+
+// Load builds the configuration from, in increasing order of precedence: the defaults, the YAML or TOML
+// file named by CONFIG_FILE or -config, environment variables, and the flags in args.
+// A file thus holds the settings shared by every deployment, and the environment adjusts them per deployment.
+// Secrets are never accepted as plain flags, only as files. Load does not validate the result
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+	settings := cfg.settings()
+
+	flags := flag.NewFlagSet("go-gin-postgres", flag.ContinueOnError)
+	flags.Usage = func() {
+		fmt.Fprintf(flags.Output(), "Usage of %s:\n", flags.Name())
+		fmt.Fprintln(flags.Output(), "Settings are read from the defaults, then the -config file, then environment variables, then these flags,")
+		fmt.Fprintln(flags.Output(), "each overriding the one before.")
+		flags.PrintDefaults()
+	}
+	path := flags.String("config", os.Getenv("CONFIG_FILE"), "YAML or TOML config file")
+	values := map[string]*string{}
+	for _, s := range settings {
+		name, usage := s.flag, s.usage
+		if s.secret {
+			name, usage = name+"-file", "file holding the "+usage
+		}
+		values[name] = flags.String(name, "", usage)
+	}
+	if err := flags.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *path != "" {
+		file, err := readFile(*path)
+		if err != nil {
+			return nil, err
+		}
+		if err := applyFile(settings, file); err != nil {
+			return nil, fmt.Errorf("%s: %w", *path, err)
+		}
+	}
+
+	for _, s := range settings {
+		if err := s.fromEnv(); err != nil {
+			return nil, err
+		}
+	}
+
+	var err error
+	flags.Visit(func(f *flag.Flag) {
+		for _, s := range settings {
+			if err != nil {
+				return
+			}
+			switch {
+			case s.secret && f.Name == s.flag+"-file":
+				err = s.fromFile("-"+f.Name, *values[f.Name])
+			case !s.secret && f.Name == s.flag:
+				err = s.apply("-"+f.Name, *values[f.Name])
+			}
+		}
+	})
+	if err != nil {
+		return nil, err
+	}
+	return cfg, nil
+}
+
+// fromEnv applies the environment variable of s, or for secrets the file named by <env>_FILE
+func (s setting) fromEnv() error {
+	if value, ok := os.LookupEnv(s.env); ok {
+		return s.apply(s.env, value)
+	}
+	if path, ok := os.LookupEnv(s.env + "_FILE"); ok && s.secret {
+		return s.fromFile(s.env+"_FILE", path)
+	}
+	return nil
+}
+
+// fromFile applies the content of the file at path, without its trailing newline
+func (s setting) fromFile(source, path string) error {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return s.apply(source, strings.TrimRight(string(content), "\r\n"))
+}
+
+func (s setting) apply(source, value string) error {
+	if err := s.set(value); err != nil {
+		return fmt.Errorf("%s: %w", source, err)
+	}
+	return nil
+}
+
+// readFile decodes a YAML or TOML config file, chosen by its extension
+func readFile(path string) (map[string]interface{}, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	file := map[string]interface{}{}
+	switch ext := strings.ToLower(filepath.Ext(path)); ext {
+	case ".yaml", ".yml":
+		err = yaml.Unmarshal(content, &file)
+	case ".toml":
+		err = toml.Unmarshal(content, &file)
+	default:
+		return nil, fmt.Errorf("config file %s: unsupported extension %q, use .yaml, .yml or .toml", path, ext)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("config file %s: %w", path, err)
+	}
+	return file, nil
+}
+
+// applyFile applies the values of a decoded config file. Unknown keys are an error, so that typos do not go unnoticed
+func applyFile(settings []setting, file map[string]interface{}) error {
+	leaves := map[string]interface{}{}
+	flatten("", file, leaves)
+	for _, s := range settings {
+		if value, ok := leaves[s.key]; ok {
+			delete(leaves, s.key)
+			if err := s.apply(s.key, fileValue(value)); err != nil {
+				return err
+			}
+		}
+		if value, ok := leaves[s.key+"_file"]; ok && s.secret {
+			delete(leaves, s.key+"_file")
+			if err := s.fromFile(s.key+"_file", fileValue(value)); err != nil {
+				return err
+			}
+		}
+	}
+	for key := range leaves {
+		return fmt.Errorf("unknown setting %q", key)
+	}
+	return nil
+}
+
+// flatten collects the leaves of nested tables under dotted keys
+func flatten(prefix string, table map[string]interface{}, leaves map[string]interface{}) {
+	for key, value := range table {
+		if nested, ok := value.(map[string]interface{}); ok {
+			flatten(prefix+key+".", nested, leaves)
+			continue
+		}
+		leaves[prefix+key] = value
+	}
+}
+
+// fileValue formats a decoded value the way it would be written in an environment variable
+func fileValue(value interface{}) string {
+	if list, ok := value.([]interface{}); ok {
+		items := make([]string, len(list))
+		for i, item := range list {
+			items[i] = fmt.Sprint(item)
+		}
+		return strings.Join(items, ",")
+	}
+	return fmt.Sprint(value)
+}
+
+func stringVar(target *string) func(string) error {
+	return func(value string) error {
+		*target = value
+		return nil
+	}
+}
+
+func intVar(target *int) func(string) error {
+	return func(value string) error {
+		n, err := strconv.Atoi(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a number", value)
+		}
+		*target = n
+		return nil
+	}
+}
+
+func boolVar(target *bool) func(string) error {
+	return func(value string) error {
+		b, err := strconv.ParseBool(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a boolean", value)
+		}
+		*target = b
+		return nil
+	}
+}
+
+func durationVar(target *time.Duration) func(string) error {
+	return func(value string) error {
+		d, err := time.ParseDuration(value)
+		if err != nil {
+			return fmt.Errorf("%q is not a duration such as 30s or 5m", value)
+		}
+		*target = d
+		return nil
+	}
+}
+
+func listVar(target *[]string) func(string) error {
+	return func(value string) error {
+		*target = nil
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				*target = append(*target, item)
+			}
+		}
+		return nil
+	}
+}
+
+// Validate reports every invalid setting at once
+func (c *Config) Validate() error {
+	return errors.Join(c.Server.Validate(), c.Database.Validate(), c.JWT.Validate(), c.Mail.Validate(), c.Log.Validate())
+}
+
+// Validate checks the listener settings
+func (s Server) Validate() error {
+	var errs []error
+	if s.Addr == "" {
+		errs = append(errs, errors.New("server.addr is required"))
+	}
+	if u, err := url.Parse(s.BaseURL); err != nil || u.Scheme == "" || u.Host == "" {
+		errs = append(errs, fmt.Errorf("server.base_url %q must be an absolute URL", s.BaseURL))
+	}
+	for _, proxy := range s.TrustedProxies {
+		if _, _, err := net.ParseCIDR(proxy); err != nil && net.ParseIP(proxy) == nil {
+			errs = append(errs, fmt.Errorf("server.trusted_proxies entry %q is not an IP or CIDR", proxy))
+		}
+	}
+	return errors.Join(errs...)
+}
+
+// Validate checks the connection settings
+func (d Database) Validate() error {
+	var errs []error
+	if d.Host == "" || d.User == "" || d.Name == "" {
+		errs = append(errs, errors.New("database.host, database.user and database.name are required"))
+	}
+	if d.Port < 1 || d.Port > 65535 {
+		errs = append(errs, fmt.Errorf("database.port %d is out of range", d.Port))
+	}
+	switch d.SSLMode {
+	case "disable", "allow", "prefer", "require", "verify-ca", "verify-full":
+	default:
+		errs = append(errs, fmt.Errorf("database.sslmode %q is not a Postgres sslmode", d.SSLMode))
+	}
+	if d.MaxOpenConns < 0 || d.MaxIdleConns < 0 {
+		errs = append(errs, errors.New("database.max_open_conns and database.max_idle_conns cannot be negative"))
+	}
+	if d.MaxOpenConns > 0 && d.MaxIdleConns > d.MaxOpenConns {
+		errs = append(errs, fmt.Errorf("database.max_idle_conns %d is above database.max_open_conns %d", d.MaxIdleConns, d.MaxOpenConns))
+	}
+	if d.ConnMaxLifetime < 0 || d.ConnMaxIdleTime < 0 {
+		errs = append(errs, errors.New("database.conn_max_lifetime and database.conn_max_idle_time cannot be negative"))
+	}
+	if d.ConnectAttempts < 1 {
+		errs = append(errs, errors.New("database.connect_attempts must be at least 1"))
+	}
+	if d.ConnectBackoff <= 0 || d.ConnectMaxBackoff < d.ConnectBackoff {
+		errs = append(errs, errors.New("database.connect_backoff must be positive and at most database.connect_max_backoff"))
+	}
+	if d.LegacyTimeZone == "" {
+		errs = append(errs, errors.New("database.legacy_time_zone is required"))
+	}
+	return errors.Join(errs...)
+}
+
+// DSN returns the connection string of the database
+func (d Database) DSN() string {
+	parts := []string{
+		"host=" + quoteDSN(d.Host),
+		"port=" + strconv.Itoa(d.Port),
+		"user=" + quoteDSN(d.User),
+		"dbname=" + quoteDSN(d.Name),
+		"sslmode=" + quoteDSN(d.SSLMode),
+	}
+	if d.Password != "" {
+		parts = append(parts, "password="+quoteDSN(d.Password))
+	}
+	return strings.Join(parts, " ")
+}
+
+// quoteDSN quotes a connection string value as libpq expects
+func quoteDSN(value string) string {
+	return "'" + strings.NewReplacer(`\`, `\\`, `'`, `\'`).Replace(value) + "'"
+}
+
+// Validate checks that a signing key is configured for the algorithm
+func (j JWT) Validate() error {
+	switch j.Algorithm {
+	case "HS256":
+		if len(j.Secret) < 32 {
+			return errors.New("jwt.secret must be at least 32 bytes for HS256")
+		}
+	case "RS256", "ES256":
+		if j.PrivateKeyFile == "" {
+			return fmt.Errorf("jwt.private_key_file is required for %s", j.Algorithm)
+		}
+	default:
+		return fmt.Errorf("jwt.algorithm %q must be HS256, RS256 or ES256", j.Algorithm)
+	}
+	for _, entry := range j.PreviousKeys {
+		if _, _, ok := strings.Cut(entry, "="); !ok {
+			return fmt.Errorf("jwt.previous_keys entry %q must be kid=path", entry)
+		}
+	}
+	return nil
+}
+
+// Validate checks the mail driver settings
+func (m Mail) Validate() error {
+	switch m.Driver {
+	case "file":
+		return nil
+	case "smtp":
+		if m.SMTPHost == "" {
+			return errors.New("mail.smtp_host is required for the smtp mail driver")
+		}
+		if m.SMTPPort < 1 || m.SMTPPort > 65535 {
+			return fmt.Errorf("mail.smtp_port %d is out of range", m.SMTPPort)
+		}
+		return nil
+	}
+	return fmt.Errorf("mail.driver %q must be file or smtp", m.Driver)
+}
+
+// Validate checks the log level and format
+func (l Log) Validate() error {
+	var errs []error
+	if _, err := logrus.ParseLevel(l.Level); err != nil {
+		errs = append(errs, fmt.Errorf("log.level %q is not a log level", l.Level))
+	}
+	if l.Format != "text" && l.Format != "json" {
+		errs = append(errs, fmt.Errorf("log.format %q must be text or json", l.Format))
+	}
+	return errors.Join(errs...)
+}
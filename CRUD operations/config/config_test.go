@@ -0,0 +1,47 @@
+package config_test
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+
+	"go-gin-postgres/config"
+)
+
+func TestLoadPrecedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	file := "server:\n  addr: \":7000\"\n  base_url: https://file.example\ndatabase:\n  host: file-host\n"
+	if err := os.WriteFile(path, []byte(file), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("CONFIG_FILE", path)
+	t.Setenv("APP_ADDR", ":7100")
+	t.Setenv("DB_HOST", "env-host")
+
+	cfg, err := config.Load([]string{"-db-host", "flag-host"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		setting, got, want string
+	}{
+		{"default", cfg.Database.Name, "myapi"},
+		{"file over default", cfg.Server.BaseURL, "https://file.example"},
+		{"environment over file", cfg.Server.Addr, ":7100"},
+		{"flag over environment", cfg.Database.Host, "flag-host"},
+	} {
+		if tc.got != tc.want {
+			t.Errorf("%s: got %q, want %q", tc.setting, tc.got, tc.want)
+		}
+	}
+}
+
+func TestLoadRejectsUnknownFileKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.toml")
+	if err := os.WriteFile(path, []byte("[server]\nadress = \":7000\"\n"), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := config.Load([]string{"-config", path}); err == nil {
+		t.Error("a misspelt key was accepted")
+	}
+}
@@ -1,45 +1,160 @@
 package database
 
 import (
-	"go-gin-postgres/models"
-	"log"
-	"os"
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"go-gin-postgres/config"
+	"strings"
 	"sync"
+	"time"
 
 	"github.com/jinzhu/gorm"
 	_ "github.com/jinzhu/gorm/dialects/postgres"
 	"github.com/sirupsen/logrus"
 )
 
-var (
-	db   *gorm.DB
-	once sync.Once
-)
+// ErrUnavailable is returned by Initialize when Postgres could not be reached within the configured attempts.
+// The connection it returns along with it keeps reconnecting in the background
+var ErrUnavailable = errors.New("database unavailable")
+
+// State tracks whether the connection Initialize opened can be used yet
+type State struct {
+	db *gorm.DB
+
+	mu sync.RWMutex
+	// err is why the database cannot be used yet, nil once Postgres was reached and its schema checked
+	err error
+}
+
+// Initialize opens the database connection, along with the State that tracks it.
+// It retries with backoff while Postgres is not up; if every attempt fails it still returns the connection,
+// with an error wrapping ErrUnavailable, so that the server can start degraded and recover on its own.
+// Once Postgres answers, a schema whose recorded version is not the one of the built-in migrations, or whose
+// tables differ from models as Drift reports, is an error wrapping ErrSchemaMismatch
+func Initialize(cfg config.Database, logger *logrus.Logger, models ...interface{}) (*gorm.DB, *State, error) {
+	// Open the pool ourselves: gorm closes a pool it opened when the first ping fails
+	pool, err := Open(cfg)
+	if err != nil {
+		return nil, nil, err
+	}
 
-// Initialize initializes the database connection using the singleton pattern
-func Initialize(logger *logrus.Logger) (*gorm.DB, error) {
-	var err error
+	// Connect to PostgreSQL database
+	conn, err := gorm.Open("postgres", pool)
+	if conn == nil {
+		return nil, nil, err
+	}
+
+	// Set logger for GORM
+	conn.SetLogger(logger)
+	state := &State{db: conn, err: ErrUnavailable}
+
+	backoff := cfg.ConnectBackoff
+	for attempt := 1; err != nil && attempt < cfg.ConnectAttempts; attempt++ {
+		logger.Warnf("Database not reachable (attempt %d of %d), retrying in %v: %v", attempt, cfg.ConnectAttempts, backoff, err)
+		time.Sleep(backoff)
+		backoff = nextBackoff(backoff, cfg.ConnectMaxBackoff)
+		err = pool.Ping()
+	}
+	if err != nil {
+		go state.reconnect(cfg, logger, backoff, models)
+		return conn, state, fmt.Errorf("%w after %d attempts: %v", ErrUnavailable, cfg.ConnectAttempts, err)
+	}
+	err = state.setUp(cfg, models)
+	state.set(err)
+	return conn, state, err
+}
+
+// Open opens a connection pool to Postgres sized by cfg, without connecting yet
+func Open(cfg config.Database) (*sql.DB, error) {
+	pool, err := sql.Open("postgres", cfg.DSN())
+	if err != nil {
+		return nil, err
+	}
+	pool.SetMaxOpenConns(cfg.MaxOpenConns)
+	pool.SetMaxIdleConns(cfg.MaxIdleConns)
+	pool.SetConnMaxLifetime(cfg.ConnMaxLifetime)
+	pool.SetConnMaxIdleTime(cfg.ConnMaxIdleTime)
+	return pool, nil
+}
 
-	once.Do(func() {
-		// Connect to PostgreSQL database
-		db, err = gorm.Open("postgres", "host=localhost user=postgres dbname=myapi sslmode=disable password=12345678")
-		if err != nil {
-			logger.Fatalf("Failed to connect to database: %v", err)
+// reconnect pings Postgres until it answers, then finishes the setup Initialize could not.
+// A schema that does not match is kept for Err to report and checked again at the longest wait,
+// so that the server recovers once the database is migrated
+func (s *State) reconnect(cfg config.Database, logger *logrus.Logger, backoff time.Duration, models []interface{}) {
+	for {
+		time.Sleep(backoff)
+		if err := s.db.DB().Ping(); err != nil {
+			backoff = nextBackoff(backoff, cfg.ConnectMaxBackoff)
+			logger.Warnf("Database still not reachable, retrying in %v: %v", backoff, err)
+			continue
 		}
+		err := s.setUp(cfg, models)
+		s.set(err)
+		if err == nil {
+			logger.Info("Database reachable again")
+			return
+		}
+		// Serving against the wrong schema would corrupt data, so requests are refused until it matches
+		backoff = cfg.ConnectMaxBackoff
+		logger.Errorf("Database reachable but not usable, checking again in %v: %v", backoff, err)
+	}
+}
 
-		// Set logger for GORM
-		db.SetLogger(logger)
+// setUp checks the schema version and the tables of models once Postgres is reachable.
+// The schema is only changed by the migrate command
+func (s *State) setUp(cfg config.Database, models []interface{}) error {
+	migrator, err := NewMigrator(s.db.DB())
+	if err != nil {
+		return err
+	}
+	if err := migrator.Check(context.Background()); err != nil {
+		return err
+	}
+	differences, err := Drift(s.db, models...)
+	if err != nil {
+		return err
+	}
+	if len(differences) > 0 {
+		report := make([]string, len(differences))
+		for i, difference := range differences {
+			report[i] = difference.String()
+		}
+		return fmt.Errorf("%w: the tables differ from the models in %d places; run drift for details\n%s", ErrSchemaMismatch, len(differences), strings.Join(report, "\n"))
+	}
+	s.db.LogMode(cfg.LogQueries)
+	return nil
+}
 
-		// Auto-migrate models
-		db.AutoMigrate(&models.User{}, &models.Ticket{}, &models.Order{}, &models.Payment{})
-		db.LogMode(true)
-		db.SetLogger(log.New(os.Stdout, "\r\n", 0))
-	})
+func (s *State) set(err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.err = err
+}
+
+// nextBackoff doubles a wait, up to max
+func nextBackoff(backoff, max time.Duration) time.Duration {
+	if backoff *= 2; backoff > max {
+		return max
+	}
+	return backoff
+}
+
+// Ping checks that Postgres answers
+func (s *State) Ping(ctx context.Context) error {
+	return s.db.DB().PingContext(ctx)
+}
 
-	return db, err
+// Err returns why the database cannot be used: ErrUnavailable until Postgres is reached, then an error
+// wrapping ErrSchemaMismatch while its schema does not match this build, and nil once it does
+func (s *State) Err() error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.err
 }
 
-// GetDB returns the singleton database instance
-func GetDB() *gorm.DB {
-	return db
+// Stats returns the connection pool statistics
+func (s *State) Stats() sql.DBStats {
+	return s.db.DB().Stats()
 }
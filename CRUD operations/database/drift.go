@@ -0,0 +1,404 @@
+package database
+
+import (
+	"database/sql"
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// Kinds of schema drift
+const (
+	DriftMissingTable      = "missing_table"
+	DriftMissingColumn     = "missing_column"
+	DriftExtraColumn       = "extra_column"
+	DriftType              = "type"
+	DriftNullable          = "nullable"
+	DriftMissingIndex      = "missing_index"
+	DriftMissingForeignKey = "missing_foreign_key"
+	DriftForeignKey        = "foreign_key"
+	DriftExtraForeignKey   = "extra_foreign_key"
+)
+
+// driftTitles describe the kinds of drift in the text report
+var driftTitles = map[string]string{
+	DriftMissingTable:      "missing table",
+	DriftMissingColumn:     "missing column",
+	DriftExtraColumn:       "column not in the model",
+	DriftType:              "type differs",
+	DriftNullable:          "nullability differs",
+	DriftMissingIndex:      "missing index",
+	DriftMissingForeignKey: "missing foreign key",
+	DriftForeignKey:        "foreign key differs",
+	DriftExtraForeignKey:   "foreign key not in the model",
+}
+
+// Difference is one way the live schema differs from what a model declares.
+// Model and Database hold the two sides; either is empty when that side has nothing
+type Difference struct {
+	Table    string `json:"table"`
+	Column   string `json:"column,omitempty"`
+	Kind     string `json:"kind"`
+	Model    string `json:"model,omitempty"`
+	Database string `json:"database,omitempty"`
+}
+
+// String formats the difference as a small diff, the model's side first
+func (d Difference) String() string {
+	location := d.Table
+	if d.Column != "" {
+		location += "." + d.Column
+	}
+	s := location + ": " + driftTitles[d.Kind]
+	if d.Model != "" {
+		s += "\n  - model:    " + d.Model
+	}
+	if d.Database != "" {
+		s += "\n  + database: " + d.Database
+	}
+	return s
+}
+
+// expectedColumn is a column as a model declares it
+type expectedColumn struct {
+	name     string
+	dataType string
+	notNull  bool
+}
+
+// index is an index by its columns, in order
+type index struct {
+	columns []string
+	unique  bool
+}
+
+func (i index) String() string {
+	s := "(" + strings.Join(i.columns, ", ") + ")"
+	if i.unique {
+		return "unique " + s
+	}
+	return s
+}
+
+// Drift compares the tables of models, as their gorm tags declare them, to the live schema of db.
+// It reads columns, types and nullability from information_schema.columns, foreign keys from
+// information_schema.table_constraints, and indexes from pg_index, which information_schema does not cover.
+//
+// Index and foreign key names are not compared, only what they cover. A field tagged foreignkey is
+// expected to reference the primary key of the model it is named after, UserID the one of User,
+// when that model is among models
+func Drift(db *gorm.DB, models ...interface{}) ([]Difference, error) {
+	// Primary keys of the models by model name, for the foreign keys that point at them
+	targets := map[string]string{}
+	for _, model := range models {
+		scope := db.NewScope(model)
+		if field := scope.PrimaryField(); field != nil {
+			targets[scope.GetModelStruct().ModelType.Name()] = scope.TableName() + "(" + field.DBName + ")"
+		}
+	}
+
+	var differences []Difference
+	for _, model := range models {
+		found, err := tableDrift(db, model, targets)
+		if err != nil {
+			return nil, err
+		}
+		differences = append(differences, found...)
+	}
+	return differences, nil
+}
+
+// tableDrift compares the table of one model
+func tableDrift(db *gorm.DB, model interface{}, targets map[string]string) ([]Difference, error) {
+	scope := db.NewScope(model)
+	table := scope.TableName()
+	columns, indexes, foreignKeys := expectedSchema(scope, targets)
+
+	actualColumns, err := liveColumns(db.DB(), table)
+	if err != nil {
+		return nil, err
+	}
+	if len(actualColumns) == 0 {
+		return []Difference{{Table: table, Kind: DriftMissingTable, Model: scope.GetModelStruct().ModelType.Name()}}, nil
+	}
+	actualIndexes, err := liveIndexes(db.DB(), table)
+	if err != nil {
+		return nil, err
+	}
+	actualForeignKeys, err := liveForeignKeys(db.DB(), table)
+	if err != nil {
+		return nil, err
+	}
+
+	var differences []Difference
+	declared := map[string]bool{}
+	for _, column := range columns {
+		declared[column.name] = true
+		actual, ok := actualColumns[column.name]
+		if !ok {
+			differences = append(differences, Difference{Table: table, Column: column.name, Kind: DriftMissingColumn, Model: describeColumn(column)})
+			continue
+		}
+		if column.dataType != actual.dataType {
+			differences = append(differences, Difference{Table: table, Column: column.name, Kind: DriftType, Model: column.dataType, Database: actual.dataType})
+		}
+		if column.notNull != actual.notNull {
+			differences = append(differences, Difference{Table: table, Column: column.name, Kind: DriftNullable, Model: nullability(column.notNull), Database: nullability(actual.notNull)})
+		}
+	}
+	for _, name := range sortedColumns(actualColumns) {
+		if !declared[name] {
+			differences = append(differences, Difference{Table: table, Column: name, Kind: DriftExtraColumn, Database: describeColumn(actualColumns[name])})
+		}
+	}
+
+	for _, expected := range indexes {
+		if !hasIndex(actualIndexes, expected) {
+			differences = append(differences, Difference{Table: table, Column: strings.Join(expected.columns, ","), Kind: DriftMissingIndex, Model: expected.String()})
+		}
+	}
+
+	for _, column := range sortedKeys(foreignKeys) {
+		target := foreignKeys[column]
+		actual, ok := actualForeignKeys[column]
+		switch {
+		case !ok:
+			differences = append(differences, Difference{Table: table, Column: column, Kind: DriftMissingForeignKey, Model: "references " + target})
+		case actual != target:
+			differences = append(differences, Difference{Table: table, Column: column, Kind: DriftForeignKey, Model: "references " + target, Database: "references " + actual})
+		}
+	}
+	for _, column := range sortedKeys(actualForeignKeys) {
+		if _, ok := foreignKeys[column]; !ok {
+			differences = append(differences, Difference{Table: table, Column: column, Kind: DriftExtraForeignKey, Database: "references " + actualForeignKeys[column]})
+		}
+	}
+	return differences, nil
+}
+
+// expectedSchema reads the columns, indexes and foreign keys a model declares through its gorm tags
+func expectedSchema(scope *gorm.Scope, targets map[string]string) ([]expectedColumn, []index, map[string]string) {
+	var columns []expectedColumn
+	var indexes []index
+	foreignKeys := map[string]string{}
+	named := map[string]*index{}
+	var names []string
+	addNamed := func(name, column string, unique bool) {
+		if named[name] == nil {
+			named[name] = &index{unique: unique}
+			names = append(names, name)
+		}
+		named[name].columns = append(named[name].columns, column)
+	}
+
+	var primaryKey []string
+	dialect := scope.Dialect()
+	for _, field := range scope.GetModelStruct().StructFields {
+		if !field.IsNormal || field.IsIgnored {
+			continue
+		}
+		_, _, _, additional := gorm.ParseFieldStructForDialect(field, dialect)
+		dataType := strings.TrimSpace(strings.TrimSuffix(dialect.DataTypeOf(field), additional))
+		_, notNull := field.TagSettingsGet("NOT NULL")
+		columns = append(columns, expectedColumn{
+			name:     field.DBName,
+			dataType: canonicalType(dataType),
+			notNull:  notNull || field.IsPrimaryKey,
+		})
+
+		if field.IsPrimaryKey {
+			primaryKey = append(primaryKey, field.DBName)
+		}
+		if _, ok := field.TagSettingsGet("UNIQUE"); ok {
+			indexes = append(indexes, index{columns: []string{field.DBName}, unique: true})
+		}
+		if name, ok := field.TagSettingsGet("INDEX"); ok {
+			for _, name := range strings.Split(name, ",") {
+				if name == "INDEX" || name == "" {
+					name = "idx_" + scope.TableName() + "_" + field.DBName
+				}
+				addNamed(name, field.DBName, false)
+			}
+		}
+		if name, ok := field.TagSettingsGet("UNIQUE_INDEX"); ok {
+			for _, name := range strings.Split(name, ",") {
+				if name == "UNIQUE_INDEX" || name == "" {
+					name = "uix_" + scope.TableName() + "_" + field.DBName
+				}
+				addNamed(name, field.DBName, true)
+			}
+		}
+		if _, ok := field.TagSettingsGet("FOREIGNKEY"); ok {
+			if target, ok := targets[strings.TrimSuffix(field.Name, "ID")]; ok {
+				foreignKeys[field.DBName] = target
+			}
+		}
+	}
+	if len(primaryKey) > 0 {
+		indexes = append([]index{{columns: primaryKey, unique: true}}, indexes...)
+	}
+	for _, name := range names {
+		indexes = append(indexes, *named[name])
+	}
+	return columns, indexes, foreignKeys
+}
+
+// liveColumns reads the columns of table from information_schema
+func liveColumns(db *sql.DB, table string) (map[string]expectedColumn, error) {
+	rows, err := db.Query(`SELECT column_name, data_type, udt_name, is_nullable, character_maximum_length, numeric_precision, numeric_scale
+		FROM information_schema.columns WHERE table_schema = current_schema() AND table_name = $1`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	columns := map[string]expectedColumn{}
+	for rows.Next() {
+		var name, dataType, udtName, nullable string
+		var length, precision, scale sql.NullInt64
+		if err := rows.Scan(&name, &dataType, &udtName, &nullable, &length, &precision, &scale); err != nil {
+			return nil, err
+		}
+		switch {
+		case dataType == "ARRAY":
+			dataType = canonicalType(strings.TrimPrefix(udtName, "_")) + "[]"
+		case length.Valid:
+			dataType = fmt.Sprintf("%s(%d)", dataType, length.Int64)
+		case dataType == "numeric" && precision.Valid:
+			dataType = fmt.Sprintf("numeric(%d,%d)", precision.Int64, scale.Int64)
+		}
+		columns[name] = expectedColumn{name: name, dataType: canonicalType(dataType), notNull: nullable == "NO"}
+	}
+	return columns, rows.Err()
+}
+
+// liveIndexes reads the indexes of table from pg_index
+func liveIndexes(db *sql.DB, table string) ([]index, error) {
+	rows, err := db.Query(`SELECT ix.indisunique, array_agg(a.attname ORDER BY k.n)
+		FROM pg_index ix
+		JOIN pg_class t ON t.oid = ix.indrelid
+		JOIN pg_namespace ns ON ns.oid = t.relnamespace
+		CROSS JOIN LATERAL unnest(ix.indkey) WITH ORDINALITY AS k(attnum, n)
+		JOIN pg_attribute a ON a.attrelid = t.oid AND a.attnum = k.attnum
+		WHERE ns.nspname = current_schema() AND t.relname = $1
+		GROUP BY ix.indexrelid, ix.indisunique`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var indexes []index
+	for rows.Next() {
+		var i index
+		var columns pq.StringArray
+		if err := rows.Scan(&i.unique, &columns); err != nil {
+			return nil, err
+		}
+		i.columns = columns
+		indexes = append(indexes, i)
+	}
+	return indexes, rows.Err()
+}
+
+// liveForeignKeys reads the foreign keys of table from information_schema, as column to table(column)
+func liveForeignKeys(db *sql.DB, table string) (map[string]string, error) {
+	rows, err := db.Query(`SELECT kcu.column_name, ccu.table_name, ccu.column_name
+		FROM information_schema.table_constraints tc
+		JOIN information_schema.key_column_usage kcu
+			ON kcu.constraint_schema = tc.constraint_schema AND kcu.constraint_name = tc.constraint_name
+		JOIN information_schema.constraint_column_usage ccu
+			ON ccu.constraint_schema = tc.constraint_schema AND ccu.constraint_name = tc.constraint_name
+		WHERE tc.constraint_type = 'FOREIGN KEY' AND tc.table_schema = current_schema() AND tc.table_name = $1`, table)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	foreignKeys := map[string]string{}
+	for rows.Next() {
+		var column, targetTable, targetColumn string
+		if err := rows.Scan(&column, &targetTable, &targetColumn); err != nil {
+			return nil, err
+		}
+		foreignKeys[column] = targetTable + "(" + targetColumn + ")"
+	}
+	return foreignKeys, rows.Err()
+}
+
+// typeAliases maps Postgres type names to one spelling, so that a model's type and information_schema compare
+var typeAliases = map[string]string{
+	"serial":                      "integer",
+	"serial4":                     "integer",
+	"int":                         "integer",
+	"int4":                        "integer",
+	"bigserial":                   "bigint",
+	"serial8":                     "bigint",
+	"int8":                        "bigint",
+	"int2":                        "smallint",
+	"bool":                        "boolean",
+	"float8":                      "double precision",
+	"float4":                      "real",
+	"decimal":                     "numeric",
+	"timestamp without time zone": "timestamp",
+	"timestamp with time zone":    "timestamptz",
+	"time without time zone":      "time",
+	"character varying":           "varchar",
+	"character":                   "char",
+	"bpchar":                      "char",
+}
+
+// typeWithArgs splits "character varying(255)" into its name and "(255)"
+var typeWithArgs = regexp.MustCompile(`^([a-z0-9 ]+?)\s*(\(.*\))?(\[\])?$`)
+
+// canonicalType spells a Postgres type the same way whichever alias it was written with
+func canonicalType(t string) string {
+	t = strings.ToLower(strings.TrimSpace(t))
+	match := typeWithArgs.FindStringSubmatch(t)
+	if match == nil {
+		return t
+	}
+	name := match[1]
+	if alias, ok := typeAliases[name]; ok {
+		name = alias
+	}
+	return name + strings.ReplaceAll(match[2], " ", "") + match[3]
+}
+
+func describeColumn(column expectedColumn) string {
+	return column.dataType + " " + nullability(column.notNull)
+}
+
+func nullability(notNull bool) string {
+	if notNull {
+		return "NOT NULL"
+	}
+	return "NULL"
+}
+
+func hasIndex(indexes []index, expected index) bool {
+	for _, i := range indexes {
+		if i.unique == expected.unique && strings.Join(i.columns, ",") == strings.Join(expected.columns, ",") {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedColumns(columns map[string]expectedColumn) []string {
+	names := make([]string, 0, len(columns))
+	for name := range columns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
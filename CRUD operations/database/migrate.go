@@ -0,0 +1,320 @@
+package database
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"errors"
+	"fmt"
+	"io/fs"
+	"regexp"
+	"sort"
+	"strconv"
+
+	"github.com/lib/pq"
+)
+
+//go:embed migrations/*.sql
+var migrationFiles embed.FS
+
+// ErrSchemaMismatch is returned when the database schema version is not the one of the migrations built in
+var ErrSchemaMismatch = errors.New("database schema does not match this build")
+
+// schemaTable holds the schema version in the layout of the migrate CLI, so that either can manage a database
+const schemaTable = "schema_migrations"
+
+// migrationLock is the advisory lock held while migrating, so that two migrators never run at once
+const migrationLock = 7245211084
+
+// migrationFile matches the file names of migrations, 000001_create_users_table.up.sql
+var migrationFile = regexp.MustCompile(`^(\d+)_(.+)\.(up|down)\.sql$`)
+
+// Migration is one versioned schema change, with the SQL to apply and to revert it
+type Migration struct {
+	Version uint
+	Name    string
+	Up      string
+	Down    string
+}
+
+// MigrationStatus is a migration and whether the database has it applied
+type MigrationStatus struct {
+	Migration
+	Applied bool
+}
+
+// Migrations returns the migrations built into the binary in version order
+func Migrations() ([]Migration, error) {
+	entries, err := fs.ReadDir(migrationFiles, "migrations")
+	if err != nil {
+		return nil, err
+	}
+	byVersion := map[uint]*Migration{}
+	for _, entry := range entries {
+		match := migrationFile.FindStringSubmatch(entry.Name())
+		if match == nil {
+			return nil, fmt.Errorf("migrations/%s: not named <version>_<name>.up.sql or .down.sql", entry.Name())
+		}
+		version, err := strconv.ParseUint(match[1], 10, 32)
+		if err != nil || version == 0 {
+			return nil, fmt.Errorf("migrations/%s: invalid version", entry.Name())
+		}
+		body, err := migrationFiles.ReadFile("migrations/" + entry.Name())
+		if err != nil {
+			return nil, err
+		}
+		m, ok := byVersion[uint(version)]
+		if !ok {
+			m = &Migration{Version: uint(version), Name: match[2]}
+			byVersion[uint(version)] = m
+		}
+		if match[3] == "up" {
+			m.Up = string(body)
+		} else {
+			m.Down = string(body)
+		}
+	}
+	migrations := make([]Migration, 0, len(byVersion))
+	for _, m := range byVersion {
+		if m.Up == "" || m.Down == "" {
+			return nil, fmt.Errorf("migration %06d_%s needs both an up and a down file", m.Version, m.Name)
+		}
+		migrations = append(migrations, *m)
+	}
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	return migrations, nil
+}
+
+// Migrator applies the built-in migrations to a database and tracks its version in schema_migrations
+type Migrator struct {
+	// TimeZone is the session time zone migrations run in, which is the zone migration 15 reads times
+	// stored without one in. Empty keeps the zone of the database
+	TimeZone string
+
+	db         *sql.DB
+	migrations []Migration
+}
+
+// NewMigrator returns a migrator for db with the migrations built into the binary
+func NewMigrator(db *sql.DB) (*Migrator, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+	return &Migrator{db: db, migrations: migrations}, nil
+}
+
+// Latest returns the version of the newest migration, which is the version this build runs against
+func (m *Migrator) Latest() uint {
+	if len(m.migrations) == 0 {
+		return 0
+	}
+	return m.migrations[len(m.migrations)-1].Version
+}
+
+// Version returns the schema version of the database, 0 before the first migration.
+// dirty is set when a migration failed half-way and the schema needs repair
+func (m *Migrator) Version(ctx context.Context) (version uint, dirty bool, err error) {
+	return readVersion(ctx, m.db)
+}
+
+// Status lists every migration and whether it is applied
+func (m *Migrator) Status(ctx context.Context) ([]MigrationStatus, error) {
+	version, _, err := m.Version(ctx)
+	if err != nil {
+		return nil, err
+	}
+	status := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		status[i] = MigrationStatus{Migration: migration, Applied: migration.Version <= version}
+	}
+	return status, nil
+}
+
+// Check returns an error wrapping ErrSchemaMismatch unless the database is cleanly at the latest version.
+// It only reads the version in schema_migrations; Drift compares the tables themselves
+func (m *Migrator) Check(ctx context.Context) error {
+	version, dirty, err := m.Version(ctx)
+	if err != nil {
+		return err
+	}
+	switch {
+	case dirty:
+		return fmt.Errorf("%w: version %d is dirty, a migration failed half-way", ErrSchemaMismatch, version)
+	case version != m.Latest():
+		return fmt.Errorf("%w: the database is at version %d, this build needs %d; run migrate up", ErrSchemaMismatch, version, m.Latest())
+	}
+	return nil
+}
+
+// Up applies every migration not applied yet
+func (m *Migrator) Up(ctx context.Context) error {
+	return m.migrate(ctx, func(uint) (uint, error) { return m.Latest(), nil })
+}
+
+// Down reverts the last applied migration
+func (m *Migrator) Down(ctx context.Context) error {
+	return m.migrate(ctx, func(current uint) (uint, error) {
+		if current == 0 {
+			return 0, errors.New("no migration is applied")
+		}
+		return m.previous(current), nil
+	})
+}
+
+// To applies or reverts migrations until the database is at version; 0 reverts them all
+func (m *Migrator) To(ctx context.Context, version uint) error {
+	if _, ok := m.find(version); !ok && version != 0 {
+		return fmt.Errorf("there is no migration %d", version)
+	}
+	return m.migrate(ctx, func(uint) (uint, error) { return version, nil })
+}
+
+// Baseline records version as the schema version without running any migration, for a database whose
+// tables were created before it was managed by migrations, such as by the AutoMigrate of older servers.
+// It refuses a database that already has a version
+func (m *Migrator) Baseline(ctx context.Context, version uint) error {
+	if _, ok := m.find(version); !ok {
+		return fmt.Errorf("there is no migration %d", version)
+	}
+	return m.locked(ctx, func(conn *sql.Conn, current uint) error {
+		if current != 0 {
+			return fmt.Errorf("the database is already at version %d; baseline is only for a database without one", current)
+		}
+		return m.step(ctx, conn, "", version)
+	})
+}
+
+// migrate moves the database from its current version to the one target picks, one migration at a time.
+// Each migration runs in a transaction together with the version update, so a failure leaves the
+// database at the last migration that succeeded
+func (m *Migrator) migrate(ctx context.Context, target func(current uint) (uint, error)) error {
+	return m.locked(ctx, func(conn *sql.Conn, current uint) error {
+		version, err := target(current)
+		if err != nil {
+			return err
+		}
+		for current < version {
+			next, _ := m.find(m.next(current))
+			if err := m.step(ctx, conn, next.Up, next.Version); err != nil {
+				return fmt.Errorf("migration %06d_%s up: %w", next.Version, next.Name, err)
+			}
+			current = next.Version
+		}
+		for current > version {
+			last, _ := m.find(current)
+			previous := m.previous(current)
+			if err := m.step(ctx, conn, last.Down, previous); err != nil {
+				return fmt.Errorf("migration %06d_%s down: %w", last.Version, last.Name, err)
+			}
+			current = previous
+		}
+		return nil
+	})
+}
+
+// locked runs fn on a connection holding the migration lock, with the current version of a database that is
+// not dirty and that this build knows
+func (m *Migrator) locked(ctx context.Context, fn func(conn *sql.Conn, current uint) error) error {
+	conn, err := m.db.Conn(ctx)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	if _, err := conn.ExecContext(ctx, "SELECT pg_advisory_lock($1)", migrationLock); err != nil {
+		return err
+	}
+	defer conn.ExecContext(context.Background(), "SELECT pg_advisory_unlock($1)", migrationLock)
+
+	if _, err := conn.ExecContext(ctx, "CREATE TABLE IF NOT EXISTS "+schemaTable+" (version bigint NOT NULL PRIMARY KEY, dirty boolean NOT NULL)"); err != nil {
+		return err
+	}
+	current, dirty, err := readVersion(ctx, conn)
+	if err != nil {
+		return err
+	}
+	if dirty {
+		return fmt.Errorf("version %d is dirty, a migration failed half-way; repair the schema, then set the version with the migrate CLI's force command", current)
+	}
+	if _, ok := m.find(current); !ok && current != 0 {
+		return fmt.Errorf("the database is at version %d, which this build does not know", current)
+	}
+	return fn(conn, current)
+}
+
+// step runs one migration, if any, and records version as the schema version, or no version for 0
+func (m *Migrator) step(ctx context.Context, conn *sql.Conn, statements string, version uint) error {
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	if statements != "" {
+		if m.TimeZone != "" {
+			if _, err := tx.ExecContext(ctx, "SELECT set_config('TimeZone', $1, true)", m.TimeZone); err != nil {
+				return err
+			}
+		}
+		if _, err := tx.ExecContext(ctx, statements); err != nil {
+			return err
+		}
+	}
+	if _, err := tx.ExecContext(ctx, "DELETE FROM "+schemaTable); err != nil {
+		return err
+	}
+	if version > 0 {
+		if _, err := tx.ExecContext(ctx, "INSERT INTO "+schemaTable+" (version, dirty) VALUES ($1, false)", version); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func (m *Migrator) find(version uint) (Migration, bool) {
+	for _, migration := range m.migrations {
+		if migration.Version == version {
+			return migration, true
+		}
+	}
+	return Migration{}, false
+}
+
+// next returns the version of the first migration after version
+func (m *Migrator) next(version uint) uint {
+	for _, migration := range m.migrations {
+		if migration.Version > version {
+			return migration.Version
+		}
+	}
+	return version
+}
+
+// previous returns the version of the last migration before version, or 0
+func (m *Migrator) previous(version uint) uint {
+	previous := uint(0)
+	for _, migration := range m.migrations {
+		if migration.Version >= version {
+			break
+		}
+		previous = migration.Version
+	}
+	return previous
+}
+
+// readVersion reads schema_migrations, treating a missing table as version 0
+func readVersion(ctx context.Context, q interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+}) (uint, bool, error) {
+	var version uint
+	var dirty bool
+	err := q.QueryRowContext(ctx, "SELECT version, dirty FROM "+schemaTable+" LIMIT 1").Scan(&version, &dirty)
+	var pqErr *pq.Error
+	switch {
+	case errors.Is(err, sql.ErrNoRows):
+		return 0, false, nil
+	case errors.As(err, &pqErr) && pqErr.Code == "42P01":
+		// undefined_table: nothing was ever migrated
+		return 0, false, nil
+	}
+	return version, dirty, err
+}
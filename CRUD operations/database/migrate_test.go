@@ -0,0 +1,229 @@
+package database
+
+import (
+	"context"
+	"errors"
+	"regexp"
+	"testing"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/lib/pq"
+)
+
+// mockMigrator returns a migrator with the built-in migrations on a mocked database
+func mockMigrator(t *testing.T) (*Migrator, sqlmock.Sqlmock) {
+	t.Helper()
+	db, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+	migrator, err := NewMigrator(db)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return migrator, mock
+}
+
+// expectVersion expects schema_migrations to be read, answering version or, with a negative one, no table
+func expectVersion(mock sqlmock.Sqlmock, version int, dirty bool) {
+	query := mock.ExpectQuery(regexp.QuoteMeta("SELECT version, dirty FROM " + schemaTable))
+	if version < 0 {
+		query.WillReturnError(&pq.Error{Code: "42P01"})
+		return
+	}
+	query.WillReturnRows(sqlmock.NewRows([]string{"version", "dirty"}).AddRow(version, dirty))
+}
+
+// expectLock expects the migration lock to be taken and schema_migrations to be created
+func expectLock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_lock($1)")).WithArgs(int64(migrationLock)).WillReturnResult(sqlmock.NewResult(0, 0))
+	mock.ExpectExec("CREATE TABLE IF NOT EXISTS " + schemaTable).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+func expectUnlock(mock sqlmock.Sqlmock) {
+	mock.ExpectExec(regexp.QuoteMeta("SELECT pg_advisory_unlock($1)")).WithArgs(int64(migrationLock)).WillReturnResult(sqlmock.NewResult(0, 0))
+}
+
+// expectRecord expects version to be recorded as the schema version and the transaction to commit
+func expectRecord(mock sqlmock.Sqlmock, version uint) {
+	mock.ExpectExec("DELETE FROM " + schemaTable).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectExec("INSERT INTO " + schemaTable).WithArgs(int64(version)).WillReturnResult(sqlmock.NewResult(0, 1))
+	mock.ExpectCommit()
+}
+
+func TestMigrationsAreComplete(t *testing.T) {
+	migrations, err := Migrations()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i, migration := range migrations {
+		if migration.Version != uint(i+1) {
+			t.Errorf("migration %d has version %d, want consecutive versions", i, migration.Version)
+		}
+		if migration.Up == "" || migration.Down == "" {
+			t.Errorf("migration %06d_%s lacks an up or down file", migration.Version, migration.Name)
+		}
+	}
+}
+
+func TestCheck(t *testing.T) {
+	latest := func() int {
+		migrations, _ := Migrations()
+		return int(migrations[len(migrations)-1].Version)
+	}()
+	for _, tc := range []struct {
+		name     string
+		version  int
+		dirty    bool
+		mismatch bool
+	}{
+		{"current", latest, false, false},
+		{"never migrated", -1, false, true},
+		{"behind", latest - 1, false, true},
+		{"ahead", latest + 1, false, true},
+		{"dirty", latest, true, true},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			migrator, mock := mockMigrator(t)
+			expectVersion(mock, tc.version, tc.dirty)
+
+			err := migrator.Check(context.Background())
+			if errors.Is(err, ErrSchemaMismatch) != tc.mismatch {
+				t.Errorf("Check returned %v, want a mismatch: %v", err, tc.mismatch)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestUpAppliesPendingMigrationsInOrder(t *testing.T) {
+	migrator, mock := mockMigrator(t)
+	migrator.TimeZone = "Europe/Berlin"
+	latest := migrator.Latest()
+	expectLock(mock)
+	expectVersion(mock, int(latest-2), false)
+	for _, version := range []uint{latest - 1, latest} {
+		migration, _ := migrator.find(version)
+		mock.ExpectBegin()
+		mock.ExpectExec(regexp.QuoteMeta("SELECT set_config('TimeZone', $1, true)")).WithArgs("Europe/Berlin").WillReturnResult(sqlmock.NewResult(0, 0))
+		mock.ExpectExec(regexp.QuoteMeta(migration.Up)).WillReturnResult(sqlmock.NewResult(0, 0))
+		expectRecord(mock, version)
+	}
+	expectUnlock(mock)
+
+	if err := migrator.Up(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestFailedMigrationStopsAtTheLastGoodVersion(t *testing.T) {
+	migrator, mock := mockMigrator(t)
+	latest := migrator.Latest()
+	expectLock(mock)
+	expectVersion(mock, int(latest-1), false)
+	migration, _ := migrator.find(latest)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migration.Up)).WillReturnError(&pq.Error{Code: "P0001", Message: "users.dob is NULL in 3 rows"})
+	mock.ExpectRollback()
+	expectUnlock(mock)
+
+	err := migrator.Up(context.Background())
+	if err == nil || !regexp.MustCompile(`^migration \d+_\w+ up: .*users\.dob is NULL`).MatchString(err.Error()) {
+		t.Errorf("Up returned %v, want the failing migration and its message", err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestDownRevertsTheLastMigration(t *testing.T) {
+	migrator, mock := mockMigrator(t)
+	latest := migrator.Latest()
+	expectLock(mock)
+	expectVersion(mock, int(latest), false)
+	migration, _ := migrator.find(latest)
+	mock.ExpectBegin()
+	mock.ExpectExec(regexp.QuoteMeta(migration.Down)).WillReturnResult(sqlmock.NewResult(0, 0))
+	expectRecord(mock, latest-1)
+	expectUnlock(mock)
+
+	if err := migrator.Down(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestMigrateRefusesDirtyOrUnknownVersions(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		version int
+		dirty   bool
+	}{
+		{"dirty", 3, true},
+		{"unknown", 999, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			migrator, mock := mockMigrator(t)
+			expectLock(mock)
+			expectVersion(mock, tc.version, tc.dirty)
+			expectUnlock(mock)
+
+			if err := migrator.Up(context.Background()); err == nil {
+				t.Error("Up migrated a database it should refuse")
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestBaseline(t *testing.T) {
+	t.Run("records the version without migrating", func(t *testing.T) {
+		migrator, mock := mockMigrator(t)
+		expectLock(mock)
+		expectVersion(mock, -1, false)
+		mock.ExpectBegin()
+		expectRecord(mock, migrator.Latest())
+		expectUnlock(mock)
+
+		if err := migrator.Baseline(context.Background(), migrator.Latest()); err != nil {
+			t.Fatal(err)
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("refuses a database with a version", func(t *testing.T) {
+		migrator, mock := mockMigrator(t)
+		expectLock(mock)
+		expectVersion(mock, 3, false)
+		expectUnlock(mock)
+
+		if err := migrator.Baseline(context.Background(), migrator.Latest()); err == nil {
+			t.Error("Baseline overwrote the version of a migrated database")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+
+	t.Run("refuses an unknown version", func(t *testing.T) {
+		migrator, mock := mockMigrator(t)
+		if err := migrator.Baseline(context.Background(), migrator.Latest()+1); err == nil {
+			t.Error("Baseline recorded a version this build does not have")
+		}
+		if err := mock.ExpectationsWereMet(); err != nil {
+			t.Error(err)
+		}
+	})
+}
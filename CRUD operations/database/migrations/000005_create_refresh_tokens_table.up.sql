@@ -0,0 +1,13 @@
+CREATE TABLE IF NOT EXISTS Refresh_Tokens (
+    ID SERIAL PRIMARY KEY,
+    User_ID INT NOT NULL,
+    Family_ID VARCHAR(255) NOT NULL,
+    Token_Hash VARCHAR(255) NOT NULL UNIQUE,
+    Expires_At TIMESTAMP NOT NULL,
+    Revoked_At TIMESTAMP,
+    Created_At TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (User_ID) REFERENCES Users(ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_user_id ON Refresh_Tokens (User_ID);
+CREATE INDEX IF NOT EXISTS idx_refresh_tokens_family_id ON Refresh_Tokens (Family_ID);
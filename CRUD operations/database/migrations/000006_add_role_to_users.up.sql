@@ -0,0 +1 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS Role VARCHAR(255) NOT NULL DEFAULT 'customer';
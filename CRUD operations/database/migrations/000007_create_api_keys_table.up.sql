@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS Api_Keys (
+    ID SERIAL PRIMARY KEY,
+    User_ID INT NOT NULL,
+    Name VARCHAR(255) NOT NULL,
+    Prefix VARCHAR(255) NOT NULL,
+    Key_Hash VARCHAR(255) NOT NULL UNIQUE,
+    Scopes TEXT[] NOT NULL,
+    Expires_At TIMESTAMP,
+    Last_Used_At TIMESTAMP,
+    Revoked_At TIMESTAMP,
+    Created_At TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (User_ID) REFERENCES Users(ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_api_keys_user_id ON Api_Keys (User_ID);
@@ -0,0 +1,16 @@
+CREATE TABLE IF NOT EXISTS Login_Attempts (
+    Subject VARCHAR(255) PRIMARY KEY,
+    Failures INT NOT NULL,
+    Last_Failure_At TIMESTAMP NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS Login_Failures (
+    ID SERIAL PRIMARY KEY,
+    Email VARCHAR(255) NOT NULL,
+    IP VARCHAR(255) NOT NULL,
+    Reason VARCHAR(255) NOT NULL,
+    Created_At TIMESTAMP DEFAULT CURRENT_TIMESTAMP
+);
+
+CREATE INDEX IF NOT EXISTS idx_login_failures_email ON Login_Failures (Email);
+CREATE INDEX IF NOT EXISTS idx_login_failures_created_at ON Login_Failures (Created_At);
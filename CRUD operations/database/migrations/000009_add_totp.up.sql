@@ -0,0 +1,13 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS TOTP_Secret VARCHAR(255);
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS TOTP_Enabled BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS TOTP_Last_Step BIGINT NOT NULL DEFAULT 0;
+
+CREATE TABLE IF NOT EXISTS Recovery_Codes (
+    ID SERIAL PRIMARY KEY,
+    User_ID INT NOT NULL,
+    Code_Hash VARCHAR(255) NOT NULL,
+    Used_At TIMESTAMP,
+    FOREIGN KEY (User_ID) REFERENCES Users(ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_recovery_codes_user_id ON Recovery_Codes (User_ID);
@@ -0,0 +1,14 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS Email_Verified_At TIMESTAMP;
+
+CREATE TABLE IF NOT EXISTS User_Tokens (
+    ID SERIAL PRIMARY KEY,
+    User_ID INT NOT NULL,
+    Purpose VARCHAR(255) NOT NULL,
+    Token_Hash VARCHAR(255) NOT NULL UNIQUE,
+    Expires_At TIMESTAMP NOT NULL,
+    Used_At TIMESTAMP,
+    Created_At TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    FOREIGN KEY (User_ID) REFERENCES Users(ID)
+);
+
+CREATE INDEX IF NOT EXISTS idx_user_tokens_user_id ON User_Tokens (User_ID);
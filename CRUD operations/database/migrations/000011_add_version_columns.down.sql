@@ -0,0 +1,4 @@
+ALTER TABLE payments DROP COLUMN IF EXISTS version;
+ALTER TABLE orders DROP COLUMN IF EXISTS version;
+ALTER TABLE tickets DROP COLUMN IF EXISTS version;
+ALTER TABLE users DROP COLUMN IF EXISTS version;
\ No newline at end of file
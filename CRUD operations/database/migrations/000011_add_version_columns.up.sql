@@ -0,0 +1,4 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS Version INT NOT NULL DEFAULT 1;
+ALTER TABLE Tickets ADD COLUMN IF NOT EXISTS Version INT NOT NULL DEFAULT 1;
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Version INT NOT NULL DEFAULT 1;
+ALTER TABLE Payments ADD COLUMN IF NOT EXISTS Version INT NOT NULL DEFAULT 1;
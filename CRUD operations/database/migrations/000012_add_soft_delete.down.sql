@@ -0,0 +1,8 @@
+DROP INDEX IF EXISTS idx_payments_deleted_at;
+DROP INDEX IF EXISTS idx_orders_deleted_at;
+DROP INDEX IF EXISTS idx_tickets_deleted_at;
+DROP INDEX IF EXISTS idx_users_deleted_at;
+ALTER TABLE payments DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE orders DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE tickets DROP COLUMN IF EXISTS deleted_at;
+ALTER TABLE users DROP COLUMN IF EXISTS deleted_at;
\ No newline at end of file
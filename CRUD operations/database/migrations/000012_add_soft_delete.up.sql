@@ -0,0 +1,9 @@
+ALTER TABLE Users ADD COLUMN IF NOT EXISTS Deleted_At TIMESTAMP;
+ALTER TABLE Tickets ADD COLUMN IF NOT EXISTS Deleted_At TIMESTAMP;
+ALTER TABLE Orders ADD COLUMN IF NOT EXISTS Deleted_At TIMESTAMP;
+ALTER TABLE Payments ADD COLUMN IF NOT EXISTS Deleted_At TIMESTAMP;
+
+CREATE INDEX IF NOT EXISTS idx_users_deleted_at ON Users (Deleted_At);
+CREATE INDEX IF NOT EXISTS idx_tickets_deleted_at ON Tickets (Deleted_At);
+CREATE INDEX IF NOT EXISTS idx_orders_deleted_at ON Orders (Deleted_At);
+CREATE INDEX IF NOT EXISTS idx_payments_deleted_at ON Payments (Deleted_At);
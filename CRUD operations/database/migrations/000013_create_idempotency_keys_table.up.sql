@@ -0,0 +1,15 @@
+CREATE TABLE IF NOT EXISTS Idempotency_Keys (
+    ID SERIAL PRIMARY KEY,
+    User_ID INT NOT NULL,
+    Key VARCHAR(255) NOT NULL,
+    Fingerprint VARCHAR(255) NOT NULL,
+    Status INT NOT NULL DEFAULT 0,
+    Header TEXT,
+    Body BYTEA,
+    Created_At TIMESTAMP DEFAULT CURRENT_TIMESTAMP,
+    Expires_At TIMESTAMP NOT NULL,
+    FOREIGN KEY (User_ID) REFERENCES Users(ID)
+);
+
+CREATE UNIQUE INDEX IF NOT EXISTS idx_idempotency_keys_user_id_key ON Idempotency_Keys (User_ID, Key);
+CREATE INDEX IF NOT EXISTS idx_idempotency_keys_expires_at ON Idempotency_Keys (Expires_At);
@@ -0,0 +1,2 @@
+DROP INDEX IF EXISTS uix_users_email;
+ALTER TABLE tickets ALTER COLUMN date_paid SET DEFAULT CURRENT_TIMESTAMP;
\ No newline at end of file
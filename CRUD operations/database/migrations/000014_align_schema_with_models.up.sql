@@ -0,0 +1,13 @@
+ALTER TABLE Tickets ALTER COLUMN Date_Paid DROP DEFAULT;
+
+DO $$
+DECLARE
+    Shared BIGINT;
+BEGIN
+    SELECT COUNT(*) INTO Shared FROM (SELECT Email FROM Users GROUP BY Email HAVING COUNT(*) > 1) AS Duplicates;
+    IF Shared > 0 THEN
+        RAISE EXCEPTION '% emails are shared by more than one user; make users.email unique, then migrate again', Shared;
+    END IF;
+END $$;
+
+CREATE UNIQUE INDEX IF NOT EXISTS uix_users_email ON Users (Email);
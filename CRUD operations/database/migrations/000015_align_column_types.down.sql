@@ -0,0 +1,17 @@
+ALTER TABLE payments ALTER COLUMN created_at_time DROP NOT NULL;
+ALTER TABLE payments
+    ALTER COLUMN created_at_time TYPE TIMESTAMP USING created_at_time AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE orders ALTER COLUMN created_at_time DROP NOT NULL;
+ALTER TABLE orders
+    ALTER COLUMN created_at_time TYPE TIMESTAMP USING created_at_time AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE tickets ALTER COLUMN date_created DROP NOT NULL;
+ALTER TABLE tickets
+    ALTER COLUMN date_created TYPE TIMESTAMP USING date_created AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN date_paid TYPE TIMESTAMP USING date_paid AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE current_setting('TimeZone');
+ALTER TABLE users ALTER COLUMN dob DROP NOT NULL;
+ALTER TABLE users
+    ALTER COLUMN email_verified_at TYPE TIMESTAMP USING email_verified_at AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN deleted_at TYPE TIMESTAMP USING deleted_at AT TIME ZONE current_setting('TimeZone');
\ No newline at end of file
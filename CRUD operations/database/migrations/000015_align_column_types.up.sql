@@ -0,0 +1,36 @@
+DO $$
+BEGIN
+    IF EXISTS (SELECT 1 FROM Users WHERE Dob IS NULL) THEN
+        RAISE EXCEPTION 'users.dob is NULL in % rows; set a date of birth on them, then migrate again', (SELECT COUNT(*) FROM Users WHERE Dob IS NULL);
+    END IF;
+    IF EXISTS (SELECT 1 FROM Tickets WHERE Date_Created IS NULL) THEN
+        RAISE EXCEPTION 'tickets.date_created is NULL in % rows; set a creation time on them, then migrate again', (SELECT COUNT(*) FROM Tickets WHERE Date_Created IS NULL);
+    END IF;
+    IF EXISTS (SELECT 1 FROM Orders WHERE Created_at_Time IS NULL) THEN
+        RAISE EXCEPTION 'orders.created_at_time is NULL in % rows; set a creation time on them, then migrate again', (SELECT COUNT(*) FROM Orders WHERE Created_at_Time IS NULL);
+    END IF;
+    IF EXISTS (SELECT 1 FROM Payments WHERE Created_at_Time IS NULL) THEN
+        RAISE EXCEPTION 'payments.created_at_time is NULL in % rows; set a creation time on them, then migrate again', (SELECT COUNT(*) FROM Payments WHERE Created_at_Time IS NULL);
+    END IF;
+END $$;
+
+ALTER TABLE Users
+    ALTER COLUMN Dob SET NOT NULL,
+    ALTER COLUMN Email_Verified_At TYPE TIMESTAMPTZ USING Email_Verified_At AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN Deleted_At TYPE TIMESTAMPTZ USING Deleted_At AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE Tickets
+    ALTER COLUMN Date_Created SET NOT NULL,
+    ALTER COLUMN Date_Created TYPE TIMESTAMPTZ USING Date_Created AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN Date_Paid TYPE TIMESTAMPTZ USING Date_Paid AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN Deleted_At TYPE TIMESTAMPTZ USING Deleted_At AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE Orders
+    ALTER COLUMN Created_at_Time SET NOT NULL,
+    ALTER COLUMN Created_at_Time TYPE TIMESTAMPTZ USING Created_at_Time AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN Deleted_At TYPE TIMESTAMPTZ USING Deleted_At AT TIME ZONE current_setting('TimeZone');
+
+ALTER TABLE Payments
+    ALTER COLUMN Created_at_Time SET NOT NULL,
+    ALTER COLUMN Created_at_Time TYPE TIMESTAMPTZ USING Created_at_Time AT TIME ZONE current_setting('TimeZone'),
+    ALTER COLUMN Deleted_At TYPE TIMESTAMPTZ USING Deleted_At AT TIME ZONE current_setting('TimeZone');
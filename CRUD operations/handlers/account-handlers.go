@@ -0,0 +1,246 @@
+package handlers
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/mailer"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// verifyEmailTTL is how long an email verification link stays valid
+	verifyEmailTTL = 48 * time.Hour
+	// resetPasswordTTL is how long a password reset link stays valid
+	resetPasswordTTL = time.Hour
+)
+
+var (
+	errEmailTaken   = problem.New(http.StatusConflict, problem.CodeDuplicate, "email is already registered")
+	errInvalidToken = problem.Validation("invalid or expired token",
+		problem.FieldError{Field: "token", Reason: "invalid or expired"})
+)
+
+// RegisterRequest is the body of POST /register
+type RegisterRequest struct {
+	Name     string    `json:"name" binding:"required"`
+	Email    string    `json:"email" binding:"required,email"`
+	Password string    `json:"password" binding:"required,min=8,max=72"`
+	Dob      time.Time `json:"dob"`
+}
+
+// EmailRequest is the body of POST /password/forgot
+type EmailRequest struct {
+	Email string `json:"email" binding:"required,email"`
+}
+
+// TokenRequest is the body of POST /verify-email
+type TokenRequest struct {
+	Token string `json:"token" binding:"required"`
+}
+
+// ResetPasswordRequest is the body of POST /password/reset
+type ResetPasswordRequest struct {
+	Token    string `json:"token" binding:"required"`
+	Password string `json:"password" binding:"required,min=8,max=72"`
+}
+
+// Register creates a customer account and mails an email verification link; baseURL is prepended to mailed links
+func Register(db *gorm.DB, m mailer.Mailer, baseURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RegisterRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		var count int
+		if err := db.Model(&models.User{}).Where("email = ?", req.Email).Count(&count).Error; err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		if count > 0 {
+			problem.Abort(c, errEmailTaken)
+			return
+		}
+
+		user := models.User{
+			Name:  req.Name,
+			Email: req.Email,
+			Dob:   req.Dob,
+			Role:  auth.RoleCustomer,
+		}
+		if err := user.SetPassword(req.Password); err != nil {
+			problem.Abort(c, problem.Internal("failed to hash password", err))
+			return
+		}
+		if err := db.Create(&user).Error; err != nil {
+			problem.Abort(c, problem.Internal("failed to create user", err))
+			return
+		}
+
+		if err := sendToken(db, m, baseURL, user, models.TokenVerifyEmail, verifyEmailTTL); err != nil {
+			logrus.Errorf("failed to send verification email to %s: %v", user.Email, err)
+		}
+
+		c.JSON(http.StatusCreated, user)
+	}
+}
+
+// VerifyEmail marks the email of the token's user as verified
+func VerifyEmail(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TokenRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			token, err := consumeToken(tx, req.Token, models.TokenVerifyEmail)
+			if err != nil {
+				return err
+			}
+			return tx.Model(&models.User{}).Where("id = ?", token.UserID).UpdateColumn("email_verified_at", time.Now()).Error
+		})
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "email verified"})
+	}
+}
+
+// ForgotPassword mails a password reset link; it answers the same whether or not the email exists
+func ForgotPassword(db *gorm.DB, m mailer.Mailer, baseURL string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req EmailRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		var user models.User
+		if err := db.Where("email = ?", req.Email).First(&user).Error; err == nil {
+			if err := sendToken(db, m, baseURL, user, models.TokenResetPassword, resetPasswordTTL); err != nil {
+				logrus.Errorf("failed to send password reset email to %s: %v", user.Email, err)
+			}
+		}
+
+		c.JSON(http.StatusAccepted, gin.H{"message": "if the email is registered, a reset link has been sent"})
+	}
+}
+
+// ResetPassword sets a new password, voids every other reset link of the user and signs them out everywhere
+func ResetPassword(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req ResetPasswordRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		hash, err := models.HashPassword(req.Password)
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to hash password", err))
+			return
+		}
+
+		err = db.Transaction(func(tx *gorm.DB) error {
+			token, err := consumeToken(tx, req.Token, models.TokenResetPassword)
+			if err != nil {
+				return err
+			}
+			if err := expireTokens(tx, token.UserID, models.TokenResetPassword); err != nil {
+				return err
+			}
+			if err := tx.Model(&models.User{}).Where("id = ?", token.UserID).UpdateColumn("password", hash).Error; err != nil {
+				return err
+			}
+			return tx.Model(&models.RefreshToken{}).
+				Where("user_id = ? AND revoked_at IS NULL", token.UserID).
+				Update("revoked_at", time.Now()).Error
+		})
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "password has been reset"})
+	}
+}
+
+// reverifyEmail voids the verification links of a user whose email changed and mails one to the new address
+func reverifyEmail(db *gorm.DB, m mailer.Mailer, baseURL string, user models.User) error {
+	if err := expireTokens(db, user.ID, models.TokenVerifyEmail); err != nil {
+		return err
+	}
+	return sendToken(db, m, baseURL, user, models.TokenVerifyEmail, verifyEmailTTL)
+}
+
+// expireTokens marks every unused token of purpose of a user as used
+func expireTokens(tx *gorm.DB, userID uint, purpose string) error {
+	return tx.Model(&models.UserToken{}).
+		Where("user_id = ? AND purpose = ? AND used_at IS NULL", userID, purpose).
+		Update("used_at", time.Now()).Error
+}
+
+// sendToken stores a new token for user and mails it with a link under baseURL
+func sendToken(db *gorm.DB, m mailer.Mailer, baseURL string, user models.User, purpose string, ttl time.Duration) error {
+	token, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return err
+	}
+	record := models.UserToken{
+		UserID:    user.ID,
+		Purpose:   purpose,
+		TokenHash: auth.HashToken(token),
+		ExpiresAt: time.Now().Add(ttl),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return err
+	}
+
+	msg := mailer.Message{To: user.Email}
+	switch purpose {
+	case models.TokenVerifyEmail:
+		msg.Subject = "Verify your email address"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nPlease verify your email address by opening:\n\n%s\n\nThe link expires in %d hours.\n",
+			user.Name, tokenLink(baseURL, "/verify-email", token), int(ttl.Hours()))
+	case models.TokenResetPassword:
+		msg.Subject = "Reset your password"
+		msg.Body = fmt.Sprintf("Hi %s,\n\nYou can choose a new password by opening:\n\n%s\n\nThe link expires in %d minutes. If you did not ask for this, ignore this email.\n",
+			user.Name, tokenLink(baseURL, "/password/reset", token), int(ttl.Minutes()))
+	}
+	return m.Send(msg)
+}
+
+// tokenLink builds the URL a mailed token is sent back to
+func tokenLink(baseURL, path, token string) string {
+	return strings.TrimRight(baseURL, "/") + path + "?token=" + url.QueryEscape(token)
+}
+
+// consumeToken marks an unexpired, unused token of purpose as used and returns it
+func consumeToken(tx *gorm.DB, token, purpose string) (models.UserToken, error) {
+	var record models.UserToken
+	err := tx.Set("gorm:query_option", "FOR UPDATE").
+		Where("token_hash = ? AND purpose = ? AND used_at IS NULL AND expires_at > ?", auth.HashToken(token), purpose, time.Now()).
+		First(&record).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return record, errInvalidToken
+	}
+	if err != nil {
+		return record, err
+	}
+	return record, tx.Model(&record).Update("used_at", time.Now()).Error
+}
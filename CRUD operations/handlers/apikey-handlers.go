@@ -0,0 +1,150 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// APIKeyRequest is the body of POST /api-keys
+type APIKeyRequest struct {
+	Name      string     `json:"name" binding:"required"`
+	Scopes    []string   `json:"scopes" binding:"required,min=1"`
+	ExpiresAt *time.Time `json:"expires_at"`
+}
+
+// ResolveAPIKey returns a resolver that looks up an API key in db by its hash and returns the principal of its owner
+func ResolveAPIKey(db *gorm.DB) auth.APIKeyResolver {
+	return func(key string) (auth.Principal, error) {
+		return resolveAPIKey(db, key)
+	}
+}
+
+// resolveAPIKey returns auth.ErrInvalidAPIKey for unknown, revoked or expired keys and keys of deleted users; any other
+// failure is returned as a problem so that an unreachable database is not reported as a bad key
+func resolveAPIKey(db *gorm.DB, key string) (auth.Principal, error) {
+	var apiKey models.APIKey
+	if err := db.Where("key_hash = ? AND revoked_at IS NULL", auth.HashToken(key)).First(&apiKey).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return auth.Principal{}, auth.ErrInvalidAPIKey
+		}
+		return auth.Principal{}, problem.Internal("failed to look up api key", err)
+	}
+	if apiKey.ExpiresAt != nil && time.Now().After(*apiKey.ExpiresAt) {
+		return auth.Principal{}, auth.ErrInvalidAPIKey
+	}
+
+	var user models.User
+	if err := db.First(&user, apiKey.UserID).Error; err != nil {
+		if gorm.IsRecordNotFoundError(err) {
+			return auth.Principal{}, auth.ErrInvalidAPIKey
+		}
+		return auth.Principal{}, problem.Internal("failed to look up api key owner", err)
+	}
+
+	// The key is valid either way, so a failure to record its use is only logged
+	if err := db.Model(&apiKey).UpdateColumn("last_used_at", time.Now()).Error; err != nil {
+		logrus.Errorf("failed to record use of api key %d: %v", apiKey.ID, err)
+	}
+
+	return auth.Principal{
+		UserID:   user.ID,
+		Roles:    tokenRoles(user),
+		Scopes:   apiKey.Scopes,
+		APIKeyID: apiKey.ID,
+	}, nil
+}
+
+// CreateAPIKey issues an API key for the caller; the key itself is only ever returned here
+func CreateAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req APIKeyRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		for i, scope := range req.Scopes {
+			if !auth.ValidScope(scope) {
+				problem.Abort(c, problem.Validation("unknown scope "+strconv.Quote(scope)+`, use "*" or <resource>:read or <resource>:write`,
+					problem.FieldError{Field: "scopes[" + strconv.Itoa(i) + "]", Reason: "scope"}))
+				return
+			}
+		}
+
+		key, err := auth.GenerateAPIKey()
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to generate api key", err))
+			return
+		}
+
+		principal, _ := auth.CurrentUser(c)
+		apiKey := models.APIKey{
+			UserID:    principal.UserID,
+			Name:      req.Name,
+			Prefix:    key[:len(auth.APIKeyPrefix)+6],
+			KeyHash:   auth.HashToken(key),
+			Scopes:    req.Scopes,
+			ExpiresAt: req.ExpiresAt,
+		}
+		if err := db.Create(&apiKey).Error; err != nil {
+			problem.Abort(c, problem.Internal("failed to store api key", err))
+			return
+		}
+
+		c.JSON(http.StatusCreated, gin.H{"api_key": apiKey, "key": key})
+	}
+}
+
+// ListAPIKeys lists the caller's API keys, or every key for an admin
+func ListAPIKeys(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		page, ok := parsePage(c)
+		if !ok {
+			return
+		}
+		var apiKeys []models.APIKey
+		query := db
+		if principal, _ := auth.CurrentUser(c); !principal.IsAdmin() {
+			query = query.Where("user_id = ?", principal.UserID)
+		}
+		if err := paginate[models.APIKey](query, page).Find(&apiKeys).Error; err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		respondPage(c, page, apiKeys)
+	}
+}
+
+// RevokeAPIKey revokes one of the caller's API keys
+func RevokeAPIKey(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var apiKey models.APIKey
+		query := db
+		if principal, _ := auth.CurrentUser(c); !principal.IsAdmin() {
+			query = query.Where("user_id = ?", principal.UserID)
+		}
+		if err := query.First(&apiKey, c.Param("id")).Error; err != nil {
+			if gorm.IsRecordNotFoundError(err) {
+				err = problem.NotFound("api key not found")
+			}
+			problem.Abort(c, err)
+			return
+		}
+		if apiKey.RevokedAt == nil {
+			if err := db.Model(&apiKey).Update("revoked_at", time.Now()).Error; err != nil {
+				problem.Abort(c, problem.Internal("failed to revoke api key", err))
+				return
+			}
+		}
+		c.JSON(http.StatusOK, gin.H{"message": "api key revoked"})
+	}
+}
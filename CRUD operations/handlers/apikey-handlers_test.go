@@ -0,0 +1,89 @@
+package handlers_test
+
+import (
+	"errors"
+	"net/http"
+	"testing"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/problem"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+func TestResolveAPIKey(t *testing.T) {
+	keyRow := func() *sqlmock.Rows {
+		return sqlmock.NewRows([]string{"id", "user_id", "scopes"}).AddRow(7, 2, "{orders:read}")
+	}
+	for _, tc := range []struct {
+		name string
+		// expect sets up the queries of the lookup
+		expect func(mock sqlmock.Sqlmock)
+		// status is the status the error is answered with, 0 when the key resolves and 401 for ErrInvalidAPIKey
+		status int
+	}{
+		{"valid", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(keyRow())
+			mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(2, auth.RoleCustomer))
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "api_keys" SET "last_used_at"`).WillReturnResult(sqlmock.NewResult(0, 1))
+			mock.ExpectCommit()
+		}, 0},
+		{"use not recorded", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(keyRow())
+			mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id", "role"}).AddRow(2, auth.RoleCustomer))
+			mock.ExpectBegin()
+			mock.ExpectExec(`UPDATE "api_keys" SET "last_used_at"`).WillReturnError(errors.New("deadlock detected"))
+			mock.ExpectRollback()
+		}, 0},
+		{"unknown key", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, http.StatusUnauthorized},
+		{"deleted owner", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(keyRow())
+			mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnRows(sqlmock.NewRows([]string{"id"}))
+		}, http.StatusUnauthorized},
+		{"database down", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnError(&pq.Error{Code: "57P01"})
+		}, http.StatusServiceUnavailable},
+		{"owner lookup failed", func(mock sqlmock.Sqlmock) {
+			mock.ExpectQuery(`SELECT \* FROM "api_keys"`).WillReturnRows(keyRow())
+			mock.ExpectQuery(`SELECT \* FROM "users"`).WillReturnError(&pq.Error{Code: "42703"})
+		}, http.StatusInternalServerError},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			pool, mock, err := sqlmock.New()
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer pool.Close()
+			db, err := gorm.Open("postgres", pool)
+			if err != nil {
+				t.Fatal(err)
+			}
+			tc.expect(mock)
+
+			principal, err := handlers.ResolveAPIKey(db)("gk_secret")
+			switch {
+			case tc.status == 0:
+				if err != nil || principal.UserID != 2 || principal.APIKeyID != 7 || !principal.HasScope("orders:read") {
+					t.Errorf("resolved %+v, %v", principal, err)
+				}
+			case tc.status == http.StatusUnauthorized:
+				if !errors.Is(err, auth.ErrInvalidAPIKey) {
+					t.Errorf("failed with %v, want %v", err, auth.ErrInvalidAPIKey)
+				}
+			default:
+				if errors.Is(err, auth.ErrInvalidAPIKey) || err == nil || problem.From(err).Status != tc.status {
+					t.Errorf("failed with %v, want status %d", err, tc.status)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
@@ -3,36 +3,133 @@ package handlers
 import (
 	"go-gin-postgres/auth"
 	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+	"math"
 	"net/http"
+	"strconv"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
 )
 
-// Login authenticates user credentials and generates JWT token
-func Login() gin.HandlerFunc {
+// dummyPasswordHash is compared against when a login names no account; it matches no password anyone sends
+var dummyPasswordHash, _ = models.HashPassword("no account has this password")
+
+// LoginRequest holds the credentials posted to /login
+type LoginRequest struct {
+	Email    string `json:"email" binding:"required"`
+	Password string `json:"password" binding:"required"`
+}
+
+// Login authenticates user credentials and generates JWT token signed with keys
+func Login(db *gorm.DB, keys *auth.KeySet, guard *auth.LoginGuard) gin.HandlerFunc {
 	return func(c *gin.Context) {
+		var req LoginRequest
+
+		// Bind request body to LoginRequest struct
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		// Refuse the attempt while the account or the client IP is backing off
+		wait, err := guard.Allow(req.Email, c.ClientIP())
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to check login attempts", err))
+			return
+		}
+		if wait > 0 {
+			auditLoginFailure(db, req.Email, c.ClientIP(), "locked")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeTooManyRequests, "too many failed login attempts, try again later"))
+			return
+		}
+
+		// Look up the user and verify the password hash. An unknown email is checked against a dummy hash,
+		// so that the time taken does not tell which emails have accounts. A failed lookup is not a failed
+		// login: it is neither audited nor counted
 		var user models.User
+		switch err := db.Where("email = ?", req.Email).First(&user).Error; {
+		case gorm.IsRecordNotFoundError(err):
+			user.Password = dummyPasswordHash
+		case err != nil:
+			problem.Abort(c, problem.Internal("failed to look up user", err))
+			return
+		}
+		if !user.CheckPassword(req.Password) || user.ID == 0 {
+			reason := "bad_password"
+			if user.ID == 0 {
+				reason = "unknown_email"
+			}
+			auditLoginFailure(db, req.Email, c.ClientIP(), reason)
+			if err := guard.Failure(req.Email, c.ClientIP()); err != nil {
+				problem.Abort(c, problem.Internal("failed to record login attempt", err))
+				return
+			}
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "invalid email or password"))
+			return
+		}
 
-		// Bind request body to User struct
-		if err := c.ShouldBindJSON(&user); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		// Users with TOTP get a challenge token to exchange at /login/totp instead of real tokens
+		if user.TOTPEnabled {
+			challenge, err := keys.GenerateChallengeToken(user.ID)
+			if err != nil {
+				problem.Abort(c, problem.Internal("failed to generate token", err))
+				return
+			}
+			c.JSON(http.StatusOK, gin.H{
+				"mfa_required":    true,
+				"challenge_token": challenge,
+				"expires_in":      int(auth.ChallengeTokenTTL.Seconds()),
+			})
 			return
 		}
 
-		// Check if required fields are provided
-		if user.Name == "" || user.Email == "" || user.Password == "" {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Name, Email, and Password are required fields"})
+		if err := guard.Success(req.Email); err != nil {
+			problem.Abort(c, problem.Internal("failed to record login attempt", err))
 			return
 		}
 
-		// Generate JWT token
-		tokenString, err := auth.GenerateToken(1)
+		// Generate access and refresh tokens for a new token family
+		tokens, err := issueTokens(db, keys, user, "")
 		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate token"})
+			problem.Abort(c, problem.Internal("failed to generate token", err))
+			return
+		}
+
+		// Return tokens in response
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// UnlockUser clears the failed-login counter of a user in users so they can log in again
+func UnlockUser(users *Handlers[models.User], guard *auth.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		user, ok := users.find(c)
+		if !ok {
+			return
+		}
+		if err := guard.Unlock(user.Email); err != nil {
+			problem.Abort(c, problem.Internal("failed to unlock user", err))
 			return
 		}
+		c.JSON(http.StatusOK, gin.H{"message": "user unlocked"})
+	}
+}
 
-		// Return token in response
-		c.JSON(http.StatusOK, gin.H{"token": tokenString})
+// auditLoginFailure stores the audit record of a failed login; failures to write it are only logged
+func auditLoginFailure(db *gorm.DB, email, ip, reason string) {
+	record := models.LoginFailure{Email: email, IP: ip, Reason: reason}
+	if err := db.Create(&record).Error; err != nil {
+		logrus.Errorf("failed to audit login failure for %s: %v", email, err)
+	}
+}
+
+// JWKS publishes the public keys of keys that tokens can be verified with
+func JWKS(keys *auth.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		c.Header("Cache-Control", "public, max-age=300")
+		c.JSON(http.StatusOK, keys.JWKS())
 	}
 }
\ No newline at end of file
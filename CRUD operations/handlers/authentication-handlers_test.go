@@ -0,0 +1,84 @@
+package handlers_test
+
+import (
+	"net/http"
+	"testing"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/middleware"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	"github.com/lib/pq"
+)
+
+// loginServer serves /login from a mocked database, counting failures in store
+func loginServer(t *testing.T) (*gin.Engine, sqlmock.Sqlmock, *auth.MemoryAttemptStore) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	pool, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	db, err := gorm.Open("postgres", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := auth.ParseSigningKey("test", "HS256", []byte("a test secret of at least 32 bytes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	keys, err := auth.NewKeySet(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	store := auth.NewMemoryAttemptStore()
+
+	router := gin.New()
+	router.Use(middleware.ErrorMiddleware())
+	router.POST("/login", handlers.Login(db, keys, auth.NewLoginGuard(store)))
+	return router, mock, store
+}
+
+func TestLoginLookupFailures(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		err     error
+		status  int
+		counted bool
+	}{
+		{"unknown email", gorm.ErrRecordNotFound, http.StatusUnauthorized, true},
+		{"database down", &pq.Error{Code: "57P01"}, http.StatusServiceUnavailable, false},
+		{"query failed", &pq.Error{Code: "42703"}, http.StatusInternalServerError, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router, mock, store := loginServer(t)
+			lookup := mock.ExpectQuery(`SELECT \* FROM "users"`)
+			if tc.err == gorm.ErrRecordNotFound {
+				lookup.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				// The failure is audited
+				mock.ExpectBegin()
+				mock.ExpectQuery(`INSERT INTO "login_failures"`).WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(1))
+				mock.ExpectCommit()
+			} else {
+				lookup.WillReturnError(tc.err)
+			}
+
+			w := send(t, router, "", http.MethodPost, "/login", `{"email": "ada@example.com", "password": "secret"}`, nil)
+			if w.Code != tc.status {
+				t.Errorf("answered %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			attempt, _ := store.Get("account:ada@example.com")
+			if counted := attempt.Failures > 0; counted != tc.counted {
+				t.Errorf("failure counted: %v, want %v", counted, tc.counted)
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
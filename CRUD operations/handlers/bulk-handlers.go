@@ -0,0 +1,356 @@
+package handlers
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"reflect"
+
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+const (
+	// maxBulkItems is the most items one bulk request may carry
+	maxBulkItems = 1000
+	// bulkInsertChunk is the number of rows written by each multi-row INSERT
+	bulkInsertChunk = 200
+)
+
+// Bulk modes, chosen with ?mode=
+const (
+	// BulkAtomic writes every item or none of them
+	BulkAtomic = "atomic"
+	// BulkBestEffort writes the items that succeed and reports the rest
+	BulkBestEffort = "best_effort"
+)
+
+// BulkResult is the outcome of one item of a bulk request
+type BulkResult struct {
+	Index  int              `json:"index"`
+	Status int              `json:"status"`
+	Record interface{}      `json:"record,omitempty"`
+	Error  *problem.Problem `json:"error,omitempty"`
+}
+
+// BulkResponse is the body of every bulk response
+type BulkResponse struct {
+	Mode      string       `json:"mode"`
+	Succeeded int          `json:"succeeded"`
+	Failed    int          `json:"failed"`
+	Results   []BulkResult `json:"results"`
+}
+
+// BulkDeleteRequest is the body of DELETE /<resource>/bulk
+type BulkDeleteRequest struct {
+	IDs []uint `json:"ids" binding:"required,min=1,max=1000"`
+}
+
+// bulkItem is a decoded item waiting to be written, with its position in the request
+type bulkItem[T Model] struct {
+	index  int
+	record T
+}
+
+// errBatchAborted rolls back the transaction of an atomic bulk request in which an item failed
+var errBatchAborted = problem.New(http.StatusFailedDependency, problem.CodeBatchAborted, "not written because another item failed")
+
+// BulkCreate inserts an array or NDJSON stream of records using multi-row INSERTs
+func (h *Handlers[T]) BulkCreate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionCreate) {
+			return
+		}
+		mode, items, ok := readBulk(c)
+		if !ok {
+			return
+		}
+		response := newBulkResponse(mode, len(items))
+
+		var pending []bulkItem[T]
+		for i, raw := range items {
+			var record T
+			if err := binding.JSON.BindBody(raw, &record); err != nil {
+				response.fail(i, err)
+				continue
+			}
+			if err := h.hooks.beforeCreate(c, &record); err != nil {
+				if fatal(err) {
+					problem.Abort(c, err)
+					return
+				}
+				response.fail(i, err)
+				continue
+			}
+			*versionOf(&record) = 1
+			pending = append(pending, bulkItem[T]{index: i, record: record})
+		}
+
+		runBulk(c, h.repo, response, http.StatusCreated, func(tx repository.Repository[T]) error {
+			for start := 0; start < len(pending); start += bulkInsertChunk {
+				if err := insertChunk(c, tx, pending[start:min(start+bulkInsertChunk, len(pending))], response); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+	}
+}
+
+// BulkUpdate replaces the records in an array or NDJSON stream, matching them by primary key.
+// An item carrying a version is only written if that is still the record's version
+func (h *Handlers[T]) BulkUpdate() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionUpdate) {
+			return
+		}
+		mode, items, ok := readBulk(c)
+		if !ok {
+			return
+		}
+		response := newBulkResponse(mode, len(items))
+		pk := repository.PrimaryKey[T]()
+
+		var originals []bulkItem[T]
+		committed := runBulk(c, h.repo, response, http.StatusOK, func(tx repository.Repository[T]) error {
+			for i, raw := range items {
+				var probe T
+				if err := json.Unmarshal(raw, &probe); err != nil {
+					response.fail(i, err)
+					continue
+				}
+				id := repository.PrimaryKeyValue(probe)
+				if reflect.ValueOf(id).IsZero() {
+					response.fail(i, problem.Validation("item has no "+pk,
+						problem.FieldError{Field: pk, Reason: "required"}))
+					continue
+				}
+				want := *versionOf(&probe)
+				original, err := tx.Get(c.Request.Context(), id, h.scope(c))
+				if err != nil {
+					err = lookupError[T](err)
+				} else {
+					var record T
+					record, err = h.save(c, tx, original, func(version uint) bool {
+						return want == 0 || want == version
+					}, func(record *T) error {
+						return binding.JSON.BindBody(raw, record)
+					})
+					if err == nil {
+						originals = append(originals, bulkItem[T]{index: i, record: original})
+						response.ok(i, http.StatusOK, record)
+						continue
+					}
+				}
+				if fatal(err) {
+					return err
+				}
+				response.fail(i, err)
+			}
+			return nil
+		})
+		if committed {
+			for _, item := range originals {
+				h.hooks.afterUpdate(c, item.record, response.Results[item.index].Record.(T))
+			}
+		}
+	}
+}
+
+// BulkDelete soft-deletes the caller's records with the given IDs
+func (h *Handlers[T]) BulkDelete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionDelete) {
+			return
+		}
+		mode, ok := bulkMode(c)
+		if !ok {
+			return
+		}
+		var req BulkDeleteRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		response := newBulkResponse(mode, len(req.IDs))
+		pk := repository.PrimaryKey[T]()
+
+		runBulk(c, h.repo, response, http.StatusOK, func(tx repository.Repository[T]) error {
+			scope := h.scope(c)
+			q := repository.Query{Scope: scope}
+			q.Where = append(q.Where, repository.Condition{Column: pk, Op: "IN", Value: req.IDs})
+			found, err := tx.List(c.Request.Context(), q)
+			if err != nil {
+				return err
+			}
+			byID := map[string]T{}
+			for _, record := range found {
+				byID[fmt.Sprint(repository.PrimaryKeyValue(record))] = record
+			}
+
+			for i, id := range req.IDs {
+				record, ok := byID[fmt.Sprint(id)]
+				if !ok {
+					response.fail(i, lookupError[T](repository.ErrNotFound))
+					continue
+				}
+				err := h.hooks.beforeDelete(c, record)
+				if err == nil {
+					err = writeError(tx.Delete(c.Request.Context(), id, *versionOf(&record), scope))
+				}
+				if err != nil {
+					if fatal(err) {
+						return err
+					}
+					response.fail(i, err)
+					continue
+				}
+				response.ok(i, http.StatusOK, gin.H{pk: id})
+			}
+			return nil
+		})
+	}
+}
+
+// bulkMode reads ?mode, which defaults to atomic
+func bulkMode(c *gin.Context) (string, bool) {
+	switch mode := c.DefaultQuery("mode", BulkAtomic); mode {
+	case BulkAtomic, BulkBestEffort:
+		return mode, true
+	}
+	problem.Abort(c, problem.Validation("mode must be "+BulkAtomic+" or "+BulkBestEffort,
+		problem.FieldError{Field: "mode", Reason: "oneof " + BulkAtomic + " " + BulkBestEffort}))
+	return "", false
+}
+
+// readBulk reads the mode and the items of a bulk request: a JSON array, or one object per line
+// when the body is sent as application/x-ndjson
+func readBulk(c *gin.Context) (string, []json.RawMessage, bool) {
+	mode, ok := bulkMode(c)
+	if !ok {
+		return "", nil, false
+	}
+	body, err := c.GetRawData()
+	if err != nil {
+		problem.Abort(c, err)
+		return "", nil, false
+	}
+
+	var items []json.RawMessage
+	if c.ContentType() == "application/x-ndjson" {
+		scanner := bufio.NewScanner(bytes.NewReader(body))
+		scanner.Buffer(nil, 1<<20)
+		for scanner.Scan() {
+			if line := bytes.TrimSpace(scanner.Bytes()); len(line) > 0 {
+				items = append(items, json.RawMessage(append([]byte(nil), line...)))
+			}
+		}
+		err = scanner.Err()
+	} else {
+		err = json.Unmarshal(body, &items)
+	}
+	if err != nil {
+		problem.Abort(c, problem.New(http.StatusBadRequest, problem.CodeMalformedBody,
+			"request body must be a JSON array or application/x-ndjson"))
+		return "", nil, false
+	}
+	if len(items) == 0 || len(items) > maxBulkItems {
+		problem.Abort(c, problem.Validation(fmt.Sprintf("send between 1 and %d items", maxBulkItems)))
+		return "", nil, false
+	}
+	return mode, items, true
+}
+
+func newBulkResponse(mode string, n int) *BulkResponse {
+	return &BulkResponse{Mode: mode, Results: make([]BulkResult, n)}
+}
+
+func (r *BulkResponse) ok(index, status int, record interface{}) {
+	r.Results[index] = BulkResult{Index: index, Status: status, Record: record}
+}
+
+func (r *BulkResponse) fail(index int, err error) {
+	p := problem.From(err)
+	r.Results[index] = BulkResult{Index: index, Status: p.Status, Error: p}
+}
+
+// runBulk runs the writes of a bulk request in a transaction on repo and answers with status when every
+// item succeeded. Otherwise an atomic request is rolled back and answers 422, and a best-effort request
+// commits what succeeded and answers 207. It reports whether anything was committed
+func runBulk[T Model](c *gin.Context, repo repository.Repository[T], r *BulkResponse, status int, write func(tx repository.Repository[T]) error) bool {
+	err := repo.Transaction(c.Request.Context(), func(tx repository.Repository[T]) error {
+		if err := write(tx); err != nil {
+			return err
+		}
+		for _, result := range r.Results {
+			if result.Error != nil {
+				r.Failed++
+			}
+		}
+		if r.Failed > 0 && r.Mode == BulkAtomic {
+			return errBatchAborted
+		}
+		return nil
+	})
+	if errors.Is(err, errBatchAborted) {
+		for i, result := range r.Results {
+			if result.Error == nil {
+				r.Results[i] = BulkResult{Index: i, Status: errBatchAborted.Status, Error: errBatchAborted}
+			}
+		}
+		c.JSON(http.StatusUnprocessableEntity, r)
+		return false
+	}
+	if err != nil {
+		problem.Abort(c, err)
+		return false
+	}
+	r.Succeeded = len(r.Results) - r.Failed
+	if r.Failed > 0 {
+		status = http.StatusMultiStatus
+	}
+	c.JSON(status, r)
+	return true
+}
+
+// insertChunk inserts items with one statement. If that fails, each item is inserted on its own
+// to find the ones at fault. Only failures that are not the items' fault are returned
+func insertChunk[T Model](c *gin.Context, tx repository.Repository[T], items []bulkItem[T], r *BulkResponse) error {
+	records := make([]T, len(items))
+	for i, item := range items {
+		records[i] = item.record
+	}
+	err := tx.CreateMany(c.Request.Context(), records)
+	if err == nil {
+		for i, item := range items {
+			r.ok(item.index, http.StatusCreated, records[i])
+		}
+		return nil
+	}
+	if err = writeError(err); fatal(err) {
+		return err
+	}
+	for _, item := range items {
+		record := item.record
+		if err := writeError(tx.Create(c.Request.Context(), &record)); err != nil {
+			if fatal(err) {
+				return err
+			}
+			r.fail(item.index, err)
+			continue
+		}
+		r.ok(item.index, http.StatusCreated, record)
+	}
+	return nil
+}
+
+// fatal reports whether err is a failure of the server rather than of one item, such as a database outage
+func fatal(err error) bool {
+	return problem.From(err).Status >= http.StatusInternalServerError
+}
@@ -0,0 +1,114 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"mime"
+	"net/http"
+	"reflect"
+	"strings"
+
+	"go-gin-postgres/problem"
+
+	jsonpatch "github.com/evanphx/json-patch/v5"
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Media types accepted by PATCH
+const (
+	mergePatchType = "application/merge-patch+json"
+	jsonPatchType  = "application/json-patch+json"
+)
+
+// errPreconditionFailed answers an If-Match that does not name the record's current version
+var errPreconditionFailed = problem.New(http.StatusPreconditionFailed, problem.CodePreconditionFailed,
+	"the record has changed since it was read; fetch it again and retry")
+
+// versionOf returns a pointer to the Version field of record
+func versionOf[T Model](record *T) *uint {
+	return reflect.ValueOf(record).Elem().FieldByName("Version").Addr().Interface().(*uint)
+}
+
+// etag formats a record version as a strong entity tag
+func etag(version uint) string {
+	return fmt.Sprintf(`"%d"`, version)
+}
+
+// ifMatch reports whether the If-Match header, if any, names version
+func ifMatch(c *gin.Context, version uint) bool {
+	header := c.GetHeader("If-Match")
+	if header == "" {
+		return true
+	}
+	for _, tag := range strings.Split(header, ",") {
+		if tag = strings.TrimSpace(tag); tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// ifNoneMatch reports whether the If-None-Match header names version, so that a GET can answer 304
+func ifNoneMatch(c *gin.Context, version uint) bool {
+	for _, tag := range strings.Split(c.GetHeader("If-None-Match"), ",") {
+		if tag = strings.TrimSpace(strings.TrimPrefix(strings.TrimSpace(tag), "W/")); tag == "*" || tag == etag(version) {
+			return true
+		}
+	}
+	return false
+}
+
+// applyPatch applies body to record as a JSON Merge Patch (RFC 7386) or a JSON Patch (RFC 6902),
+// chosen by contentType, and validates the result
+func applyPatch[T Model](contentType string, body []byte, record *T) error {
+	mediaType, _, _ := mime.ParseMediaType(contentType)
+	document, err := json.Marshal(record)
+	if err != nil {
+		return err
+	}
+	var patched []byte
+	switch mediaType {
+	case mergePatchType, binding.MIMEJSON:
+		patched, err = jsonpatch.MergePatch(document, body)
+		if err != nil {
+			return problem.New(http.StatusBadRequest, problem.CodeMalformedBody, "request body is not a valid merge patch")
+		}
+	case jsonPatchType:
+		patch, err := jsonpatch.DecodePatch(body)
+		if err != nil {
+			return problem.New(http.StatusBadRequest, problem.CodeMalformedBody, "request body is not a valid JSON patch")
+		}
+		patched, err = patch.Apply(document)
+		if err != nil {
+			return problem.Conflict("the patch could not be applied: " + err.Error())
+		}
+	default:
+		return problem.New(http.StatusUnsupportedMediaType, problem.CodeUnsupportedMediaType,
+			"use Content-Type "+mergePatchType+" or "+jsonPatchType)
+	}
+	return decodePatched(document, patched, record)
+}
+
+// decodePatched decodes a patched document onto record. Fields the patch removed are decoded as null,
+// which clears nullable fields; decoding onto the loaded record keeps fields the JSON form leaves out,
+// such as the password hash
+func decodePatched[T Model](document, patched []byte, record *T) error {
+	var before, after map[string]json.RawMessage
+	if err := json.Unmarshal(document, &before); err != nil {
+		return err
+	}
+	if err := json.Unmarshal(patched, &after); err != nil {
+		return problem.New(http.StatusBadRequest, problem.CodeMalformedBody, "the patched document is not a JSON object")
+	}
+	for key := range before {
+		if _, ok := after[key]; !ok {
+			after[key] = json.RawMessage("null")
+		}
+	}
+	body, err := json.Marshal(after)
+	if err != nil {
+		return err
+	}
+	return binding.JSON.BindBody(body, record)
+}
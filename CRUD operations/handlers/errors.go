@@ -0,0 +1,37 @@
+package handlers
+
+import (
+	"reflect"
+	"strings"
+	"time"
+
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// resourceName returns the lower-case name of T used in error messages
+func resourceName[T any]() string {
+	return strings.ToLower(reflect.TypeOf(new(T)).Elem().Name())
+}
+
+// lookupError turns the error of loading a T into a problem, reporting a missing row as "<resource> not found"
+func lookupError[T any](err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return problem.NotFound(resourceName[T]() + " not found")
+	}
+	return err
+}
+
+// timeParam parses the path parameter name with layout, aborting with a 400 when it does not match.
+// format is the layout as shown to clients, such as YYYY-MM-DD
+func timeParam(c *gin.Context, name, layout, format string) (time.Time, bool) {
+	t, err := time.Parse(layout, c.Param(name))
+	if err != nil {
+		problem.Abort(c, problem.Validation("invalid "+name+", use "+format,
+			problem.FieldError{Field: name, Reason: "format " + format}))
+		return time.Time{}, false
+	}
+	return t, true
+}
@@ -0,0 +1,306 @@
+package handlers
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strings"
+	"time"
+
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// exportFlushEvery is the number of rows written between flushes of an export
+const exportFlushEvery = 500
+
+// exportFormat returns "ndjson" or "csv" when the request asks for a streaming export through
+// ?format or the Accept header, and "" for a normal paginated response
+func exportFormat(c *gin.Context) string {
+	switch c.Query("format") {
+	case "ndjson", "csv":
+		return c.Query("format")
+	}
+	accept := c.GetHeader("Accept")
+	switch {
+	case strings.Contains(accept, "application/x-ndjson"):
+		return "ndjson"
+	case strings.Contains(accept, "text/csv"):
+		return "csv"
+	}
+	return ""
+}
+
+// streamRecords writes every record of q in repo in format, reading them one at a time and
+// flushing as it goes so memory stays flat whatever the size of the result.
+// Nothing is written before the first record, so a query that fails up front still answers a problem
+func streamRecords[T any](c *gin.Context, repo repository.Repository[T], q repository.Query, format string, query ListQuery) {
+	fields := query.Fields
+	if len(fields) == 0 {
+		fields = queryFieldList[T]()
+	}
+
+	name := resourceName[T]() + "s"
+	var start func() error
+	var write func(record *T) error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(c.Writer)
+		start = func() error {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", fmt.Sprintf(`attachment; filename="%s.csv"`, name))
+			c.Status(200)
+			header := make([]string, len(fields))
+			for i, field := range fields {
+				header[i] = field.JSON
+			}
+			return w.Write(header)
+		}
+		write = func(record *T) error {
+			value := reflect.ValueOf(record).Elem()
+			row := make([]string, len(fields))
+			for i, field := range fields {
+				row[i] = csvValue(value.FieldByName(field.Name))
+			}
+			if err := w.Write(row); err != nil {
+				return err
+			}
+			w.Flush()
+			return w.Error()
+		}
+		defer w.Flush()
+	default:
+		encoder := json.NewEncoder(c.Writer)
+		start = func() error {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(200)
+			return nil
+		}
+		write = func(record *T) error {
+			if len(query.Fields) == 0 {
+				return encoder.Encode(record)
+			}
+			projected, err := query.Project([]T{*record})
+			if err != nil {
+				return err
+			}
+			return encoder.Encode(projected[0])
+		}
+	}
+
+	started := false
+	count := 0
+	err := repo.Query(c.Request.Context(), q, func(record T) error {
+		if !started {
+			started = true
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		if err := write(&record); err != nil {
+			return err
+		}
+		count++
+		if count%exportFlushEvery == 0 {
+			c.Writer.Flush()
+		}
+		return nil
+	})
+	switch {
+	case err != nil && !started:
+		problem.Abort(c, err)
+		return
+	case err != nil:
+		logrus.Errorf("export of %s aborted after %d rows: %v", name, count, err)
+	case !started:
+		// An empty export still gets its headers, and the CSV header row
+		if err := start(); err != nil {
+			logrus.Errorf("export of %s failed: %v", name, err)
+		}
+	}
+	c.Writer.Flush()
+}
+
+// relatedRecord is one line of a records export, a ticket with its user, orders and payments
+type relatedRecord[T, U, O, P any] struct {
+	Ticket   T   `json:"ticket"`
+	User     *U  `json:"user"`
+	Orders   []O `json:"orders"`
+	Payments []P `json:"payments"`
+}
+
+// streamRelated writes every ticket of scope with its user, orders and payments in format. Tickets are read
+// from a cursor in batches of exportFlushEvery and the records of each batch are looked up together, so memory
+// stays flat as in streamRecords. NDJSON has one relatedRecord per line. CSV has one row per record, each ticket
+// after its user and before its orders and payments, under a header of "resource" and the fields of all four;
+// the cells of the fields a resource does not have are empty
+func streamRelated[T TicketModel, U Model, O OrderModel, P PaymentModel](c *gin.Context, tickets *Handlers[T], users *Handlers[U], orders *Handlers[O], payments *Handlers[P], scope repository.Scope, format string) {
+	var start func() error
+	var write func(record relatedRecord[T, U, O, P]) error
+	switch format {
+	case "csv":
+		w := csv.NewWriter(c.Writer)
+		header := []string{"resource"}
+		userRow := csvRow[U](&header)
+		ticketRow := csvRow[T](&header)
+		orderRow := csvRow[O](&header)
+		paymentRow := csvRow[P](&header)
+		start = func() error {
+			c.Header("Content-Type", "text/csv; charset=utf-8")
+			c.Header("Content-Disposition", `attachment; filename="records.csv"`)
+			c.Status(200)
+			return w.Write(header)
+		}
+		write = func(record relatedRecord[T, U, O, P]) error {
+			var rows [][]string
+			if record.User != nil {
+				rows = append(rows, userRow(record.User, len(header)))
+			}
+			rows = append(rows, ticketRow(&record.Ticket, len(header)))
+			for i := range record.Orders {
+				rows = append(rows, orderRow(&record.Orders[i], len(header)))
+			}
+			for i := range record.Payments {
+				rows = append(rows, paymentRow(&record.Payments[i], len(header)))
+			}
+			return w.WriteAll(rows)
+		}
+	default:
+		encoder := json.NewEncoder(c.Writer)
+		start = func() error {
+			c.Header("Content-Type", "application/x-ndjson")
+			c.Status(200)
+			return nil
+		}
+		write = func(record relatedRecord[T, U, O, P]) error {
+			return encoder.Encode(record)
+		}
+	}
+
+	ctx := c.Request.Context()
+	started := false
+	count := 0
+	batch := make([]T, 0, exportFlushEvery)
+	// flush writes the tickets of the batch with their related records
+	flush := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		related, err := findRelated(ctx, users, orders, payments, batch)
+		if err != nil {
+			return err
+		}
+		usersByID := make(map[interface{}]*U, len(related.Users))
+		for i := range related.Users {
+			usersByID[repository.PrimaryKeyValue(&related.Users[i])] = &related.Users[i]
+		}
+		ordersByTicket := map[interface{}][]O{}
+		for _, order := range related.Orders {
+			ticketID, _ := repository.ColumnValue(&order, "ticket_id")
+			ordersByTicket[ticketID] = append(ordersByTicket[ticketID], order)
+		}
+		paymentsByTicket := map[interface{}][]P{}
+		for _, payment := range related.Payments {
+			ticketID, _ := repository.ColumnValue(&payment, "ticket_id")
+			paymentsByTicket[ticketID] = append(paymentsByTicket[ticketID], payment)
+		}
+
+		if !started {
+			started = true
+			if err := start(); err != nil {
+				return err
+			}
+		}
+		for _, ticket := range batch {
+			record := relatedRecord[T, U, O, P]{
+				Ticket:   ticket,
+				User:     usersByID[ticket.GetUserID()],
+				Orders:   ordersByTicket[ticket.GetTicketID()],
+				Payments: paymentsByTicket[ticket.GetTicketID()],
+			}
+			if record.Orders == nil {
+				record.Orders = []O{}
+			}
+			if record.Payments == nil {
+				record.Payments = []P{}
+			}
+			if err := write(record); err != nil {
+				return err
+			}
+			count++
+		}
+		batch = batch[:0]
+		c.Writer.Flush()
+		return nil
+	}
+	err := tickets.repo.Query(ctx, repository.Query{Scope: scope}, func(ticket T) error {
+		batch = append(batch, ticket)
+		if len(batch) == exportFlushEvery {
+			return flush()
+		}
+		return nil
+	})
+	if err == nil {
+		err = flush()
+	}
+	switch {
+	case err != nil && !started:
+		problem.Abort(c, err)
+		return
+	case err != nil:
+		logrus.Errorf("export of records aborted after %d tickets: %v", count, err)
+	case !started:
+		// An empty export still gets its headers, and the CSV header row
+		if err := start(); err != nil {
+			logrus.Errorf("export of records failed: %v", err)
+		}
+	}
+	c.Writer.Flush()
+}
+
+// csvRow adds the JSON names of the fields of T that header does not have yet to it, and returns a function
+// that formats a T as a row of width cells, starting with its resource name, with its fields under their names
+func csvRow[T any](header *[]string) func(record *T, width int) []string {
+	fields := queryFieldList[T]()
+	cells := make([]int, len(fields))
+	for i, field := range fields {
+		cells[i] = -1
+		for j, name := range *header {
+			if name == field.JSON {
+				cells[i] = j
+			}
+		}
+		if cells[i] < 0 {
+			cells[i] = len(*header)
+			*header = append(*header, field.JSON)
+		}
+	}
+	resource := resourceName[T]()
+	return func(record *T, width int) []string {
+		value := reflect.ValueOf(record).Elem()
+		row := make([]string, width)
+		row[0] = resource
+		for i, field := range fields {
+			row[cells[i]] = csvValue(value.FieldByName(field.Name))
+		}
+		return row
+	}
+}
+
+// csvValue formats one struct field as a CSV cell
+func csvValue(v reflect.Value) string {
+	if v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return ""
+		}
+		v = v.Elem()
+	}
+	if t, ok := v.Interface().(time.Time); ok {
+		return t.Format(time.RFC3339)
+	}
+	return fmt.Sprint(v.Interface())
+}
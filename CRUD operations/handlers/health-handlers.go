@@ -0,0 +1,65 @@
+package handlers
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"net/http"
+	"time"
+
+	"go-gin-postgres/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/sirupsen/logrus"
+)
+
+// healthPingTimeout bounds the database ping of a health check
+const healthPingTimeout = 2 * time.Second
+
+// DatabaseState is what Health reports on; database.State implements it
+type DatabaseState interface {
+	// Ping checks that the database answers
+	Ping(ctx context.Context) error
+	// Err returns why the database cannot be used yet, nil once it can
+	Err() error
+	// Stats returns the connection pool statistics
+	Stats() sql.DBStats
+}
+
+// Health reports whether the API can reach Postgres, along with the connection pool statistics.
+// It answers 503 while the server runs degraded or its schema does not match, so that load balancers
+// stop sending it traffic
+func Health(db DatabaseState) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), healthPingTimeout)
+		defer cancel()
+
+		status, state := http.StatusOK, "ok"
+		failure := db.Err()
+		if err := db.Ping(ctx); err != nil {
+			logrus.Warnf("health check: %v", err)
+			status, state = http.StatusServiceUnavailable, "degraded"
+		} else if errors.Is(failure, database.ErrSchemaMismatch) {
+			status, state = http.StatusServiceUnavailable, "schema_mismatch"
+		} else if failure != nil {
+			status, state = http.StatusServiceUnavailable, "starting"
+		}
+
+		stats := db.Stats()
+		report := gin.H{
+			"ready":               failure == nil,
+			"max_open":            stats.MaxOpenConnections,
+			"open":                stats.OpenConnections,
+			"in_use":              stats.InUse,
+			"idle":                stats.Idle,
+			"wait_count":          stats.WaitCount,
+			"wait_duration_ms":    stats.WaitDuration.Milliseconds(),
+			"max_idle_closed":     stats.MaxIdleClosed,
+			"max_lifetime_closed": stats.MaxLifetimeClosed,
+		}
+		if failure != nil {
+			report["error"] = failure.Error()
+		}
+		c.JSON(status, gin.H{"status": state, "database": report})
+	}
+}
@@ -0,0 +1,73 @@
+package handlers_test
+
+import (
+	"context"
+	"database/sql"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gin-postgres/database"
+	"go-gin-postgres/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+// fakeDatabase is a handlers.DatabaseState with a fixed answer
+type fakeDatabase struct {
+	ping error
+	err  error
+}
+
+func (f fakeDatabase) Ping(context.Context) error { return f.ping }
+func (f fakeDatabase) Err() error                 { return f.err }
+func (f fakeDatabase) Stats() sql.DBStats {
+	return sql.DBStats{MaxOpenConnections: 25, OpenConnections: 3}
+}
+
+func TestHealth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	unreachable := errors.New("connection refused")
+	for _, tc := range []struct {
+		name     string
+		database fakeDatabase
+		status   int
+		state    string
+	}{
+		{"ready", fakeDatabase{}, http.StatusOK, "ok"},
+		{"unreachable", fakeDatabase{ping: unreachable, err: database.ErrUnavailable}, http.StatusServiceUnavailable, "degraded"},
+		{"not checked yet", fakeDatabase{err: database.ErrUnavailable}, http.StatusServiceUnavailable, "starting"},
+		{"schema mismatch", fakeDatabase{err: fmt.Errorf("%w: the database is at version 14", database.ErrSchemaMismatch)}, http.StatusServiceUnavailable, "schema_mismatch"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			router := gin.New()
+			router.GET("/health", handlers.Health(tc.database))
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/health", nil))
+
+			var body struct {
+				Status   string `json:"status"`
+				Database struct {
+					Ready bool   `json:"ready"`
+					Open  int    `json:"open"`
+					Error string `json:"error"`
+				} `json:"database"`
+			}
+			if err := json.Unmarshal(w.Body.Bytes(), &body); err != nil {
+				t.Fatalf("%v in %s", err, w.Body)
+			}
+			if w.Code != tc.status || body.Status != tc.state {
+				t.Errorf("answered %d %q, want %d %q", w.Code, body.Status, tc.status, tc.state)
+			}
+			if body.Database.Ready != (tc.database.err == nil) || body.Database.Open != 3 {
+				t.Errorf("reported %+v", body.Database)
+			}
+			if tc.database.err != nil && body.Database.Error != tc.database.err.Error() {
+				t.Errorf("reported error %q, want %q", body.Database.Error, tc.database.err)
+			}
+		})
+	}
+}
@@ -0,0 +1,59 @@
+package handlers
+
+import (
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ListChildren lists the children records whose foreignKey column points at the parent named by :id,
+// with the same pagination, filtering, sorting and exports as List
+func ListChildren[P, C Model](parents *Handlers[P], children *Handlers[C], foreignKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		parentID, ok := findParent(c, parents)
+		if !ok {
+			return
+		}
+		scope := children.scope(c)
+		scope.Where = append(scope.Where, repository.Condition{Column: foreignKey, Op: "=", Value: parentID})
+		children.list(c, scope)
+	}
+}
+
+// CreateChild creates a child record under the parent named by :id. The foreignKey column always points at
+// the parent, whatever the body says
+func CreateChild[P, C Model](parents *Handlers[P], children *Handlers[C], foreignKey string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[C](c, ActionCreate) {
+			return
+		}
+		parentID, ok := findParent(c, parents)
+		if !ok {
+			return
+		}
+		var record C
+		if err := c.ShouldBindJSON(&record); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		if err := repository.SetColumn(&record, foreignKey, parentID); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		children.create(c, record)
+	}
+}
+
+// findParent loads the parent named by :id among the records the caller can see and returns its primary key.
+// A parent that is missing, deleted or someone else's answers 404
+func findParent[P Model](c *gin.Context, parents *Handlers[P]) (interface{}, bool) {
+	if !authorize[P](c, ActionRead) {
+		return nil, false
+	}
+	parent, ok := parents.find(c)
+	if !ok {
+		return nil, false
+	}
+	return repository.PrimaryKeyValue(parent), true
+}
@@ -1,21 +1,39 @@
 package handlers
 
 import (
-	"go-gin-postgres/database"
-	"net/http"
 	"time"
 
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+
 	"github.com/gin-gonic/gin"
 )
 
-func GetOrdersByDate[T Model]() gin.HandlerFunc {
+// NewOrderHandlers returns the order handlers on repo. A customer can only add orders to their own tickets
+// in tickets, and an order sent without created_at_time is created now
+func NewOrderHandlers(repo repository.Repository[models.Order], tickets repository.Repository[models.Ticket]) *Handlers[models.Order] {
+	return NewHandlers(repo, Hooks[models.Order]{
+		BeforeCreate: func(c *gin.Context, order *models.Order) error {
+			if order.CreatedAtTime.IsZero() {
+				order.CreatedAtTime = time.Now()
+			}
+			return requireOwnTicket(c, tickets, order.TicketID)
+		},
+	})
+}
+
+// GetOrdersByDate lists the orders in h created between :start_date and :end_date, YYYY-MM-DD,
+// with the same pagination, filtering, sorting and exports as List
+func GetOrdersByDate[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		startDate, _ := time.Parse("2006-01-02", c.Param("start_date"))
-		endDate, _ := time.Parse("2006-01-02", c.Param("end_date"))
-		var records []T
-		db := database.GetDB()
-		db = db.Debug()
-		db.Where("created_at_time >= ? AND created_at_time <= ?", startDate, endDate).Find(&records)
-		c.JSON(http.StatusOK, records)
+		startDate, ok := timeParam(c, "start_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		endDate, ok := timeParam(c, "end_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		h.listWhere(c, between("created_at_time", startDate, endDate)...)
 	}
-}
\ No newline at end of file
+}
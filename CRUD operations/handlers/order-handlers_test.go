@@ -0,0 +1,91 @@
+package handlers_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// orderServer serves the order routes, and orders nested under tickets, from in-memory repositories
+// holding ticket 1 of customer 2 and ticket 2 of customer 3
+func orderServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ticketRepo := repository.NewMemory(func(ticket models.Ticket, userID uint) bool {
+		return ticket.UserID == userID
+	})
+	for _, ticket := range []models.Ticket{{UserID: 2}, {UserID: 3}} {
+		if err := ticketRepo.Create(context.Background(), &ticket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	orderRepo := repository.NewMemory(func(order models.Order, userID uint) bool {
+		_, err := ticketRepo.Get(context.Background(), order.TicketID, repository.Scope{Owner: &userID})
+		return err == nil
+	})
+	tickets := handlers.NewTicketHandlers(ticketRepo)
+	orders := handlers.NewOrderHandlers(orderRepo, ticketRepo)
+
+	router := gin.New()
+	router.Use(middleware.ErrorMiddleware())
+	group := router.Group("/", func(c *gin.Context) {
+		if user := c.GetHeader("X-User"); user == "2" || user == "3" {
+			auth.SetCurrentUser(c, auth.Principal{UserID: uint(user[0] - '0'), Roles: []string{auth.RoleCustomer}})
+		}
+	})
+	handlers.RegisterResource(group, "/orders", orders)
+	ticketRoutes := handlers.RegisterResource(group, "/tickets", tickets)
+	handlers.RegisterNested(ticketRoutes, "/orders", "ticket_id", tickets, orders)
+	return router
+}
+
+func TestOrdersOnlyGoOnOwnTickets(t *testing.T) {
+	router := orderServer(t)
+
+	if w := send(t, router, "2", http.MethodPost, "/orders", `{"ticket_id": 2, "menu_item": "Tea", "quantity": 1, "price": 2.5}`, nil); w.Code != http.StatusBadRequest {
+		t.Errorf("an order on another customer's ticket answered %d, want 400", w.Code)
+	}
+
+	var order models.Order
+	w := send(t, router, "2", http.MethodPost, "/orders", `{"ticket_id": 1, "menu_item": "Tea", "quantity": 1, "price": 2.5}`, &order)
+	if w.Code != http.StatusOK || order.TicketID != 1 || order.CreatedAtTime.IsZero() {
+		t.Fatalf("an order on an own ticket answered %d %+v", w.Code, order)
+	}
+
+	var page handlers.PageResponse[models.Order]
+	send(t, router, "3", http.MethodGet, "/orders", "", &page)
+	if len(page.Data) != 0 {
+		t.Errorf("customer 3 sees %d orders of customer 2", len(page.Data))
+	}
+}
+
+func TestNestedOrders(t *testing.T) {
+	router := orderServer(t)
+
+	// The ticket in the path wins over the one in the body
+	var order models.Order
+	w := send(t, router, "2", http.MethodPost, "/tickets/1/orders", `{"ticket_id": 2, "menu_item": "Cake", "quantity": 1, "price": 4}`, &order)
+	if w.Code != http.StatusOK || order.TicketID != 1 {
+		t.Fatalf("a nested create answered %d %+v", w.Code, order)
+	}
+
+	var page handlers.PageResponse[models.Order]
+	send(t, router, "2", http.MethodGet, "/tickets/1/orders", "", &page)
+	if len(page.Data) != 1 || page.Data[0].OrderID != order.OrderID {
+		t.Errorf("ticket 1 lists %+v", page.Data)
+	}
+	if w := send(t, router, "3", http.MethodGet, "/tickets/1/orders", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("another customer's ticket answered %d, want 404", w.Code)
+	}
+	if w := send(t, router, "3", http.MethodPost, "/tickets/1/orders", `{"menu_item": "Cake", "quantity": 1, "price": 4}`, nil); w.Code != http.StatusNotFound {
+		t.Errorf("an order under another customer's ticket answered %d, want 404", w.Code)
+	}
+}
@@ -0,0 +1,31 @@
+package handlers
+
+import (
+	"go-gin-postgres/auth"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// ownerOf returns the user whose records the caller is limited to, or nil when the caller is admin or staff
+func ownerOf(c *gin.Context) *uint {
+	principal, ok := auth.CurrentUser(c)
+	if ok && principal.HasAnyRole(auth.RoleAdmin, auth.RoleStaff) {
+		return nil
+	}
+	return &principal.UserID
+}
+
+// requireOwnTicket checks that ticketID names a live ticket in tickets the caller may see, so that customers
+// can only attach orders and payments to their own tickets
+func requireOwnTicket(c *gin.Context, tickets repository.Repository[models.Ticket], ticketID uint) error {
+	_, err := tickets.Get(c.Request.Context(), ticketID, repository.Scope{Owner: ownerOf(c)})
+	if gorm.IsRecordNotFoundError(err) {
+		return problem.Validation("ticket_id does not name one of your tickets",
+			problem.FieldError{Field: "ticket_id", Reason: "exists"})
+	}
+	return err
+}
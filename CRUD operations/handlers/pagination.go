@@ -0,0 +1,238 @@
+package handlers
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+const (
+	// defaultPageSize is the page size when ?limit is not given
+	defaultPageSize = 100
+	// maxPageSize is the largest page the server hands out, whatever ?limit asks for
+	maxPageSize = 1000
+)
+
+// SortKey is one column of the ordering a page is cut from
+type SortKey = repository.Order
+
+// Page is a keyset page request: at most Limit rows that come after the cursor in Sort order.
+// The primary key always breaks ties, so After plus AfterValues identify the last row seen.
+type Page struct {
+	Limit       int
+	Sort        []SortKey
+	After       uint64
+	AfterValues []interface{}
+}
+
+// PageResponse is the body of every list endpoint
+type PageResponse[T any] struct {
+	Data       []T    `json:"data"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
+
+// cursor is the decoded form of the opaque ?cursor value. Sort records the ordering it was cut from,
+// so that a cursor carried over to another ?sort is refused instead of skipping rows
+type cursor struct {
+	After  uint64        `json:"after"`
+	Sort   string        `json:"sort,omitempty"`
+	Values []interface{} `json:"values,omitempty"`
+}
+
+// errInvalidCursor answers a cursor that is malformed, forged or from another ordering
+var errInvalidCursor = problem.Validation("invalid cursor, start again without one",
+	problem.FieldError{Field: "cursor", Reason: "invalid"})
+
+// parsePage reads ?limit and ?cursor for a page ordered by sort, answering 400 when either is invalid
+func parsePage(c *gin.Context, sort ...SortKey) (Page, bool) {
+	page := Page{Limit: defaultPageSize, Sort: sort}
+
+	if value := c.Query("limit"); value != "" {
+		limit, err := strconv.Atoi(value)
+		if err != nil || limit < 1 {
+			problem.Abort(c, problem.Validation("limit must be a positive integer",
+				problem.FieldError{Field: "limit", Reason: "min 1"}))
+			return page, false
+		}
+		page.Limit = min(limit, maxPageSize)
+	}
+
+	if value := c.Query("cursor"); value != "" {
+		decoded, err := decodeCursor(value)
+		if err != nil || decoded.Sort != sortSpec(sort) || len(decoded.Values) != len(sort) {
+			problem.Abort(c, errInvalidCursor)
+			return page, false
+		}
+		page.After = decoded.After
+		page.AfterValues = decoded.Values
+	}
+
+	return page, true
+}
+
+// typeCursor converts the cursor values of page to the Go types of the sort columns of T, answering 400
+// when one does not fit, so that a doctored cursor never reaches the database as a mistyped comparison
+func typeCursor[T any](c *gin.Context, page *Page) bool {
+	if page.After == 0 {
+		return true
+	}
+	fields := queryFields[T]()
+	for i, key := range page.Sort {
+		field, ok := fields[key.Column]
+		if !ok {
+			problem.Abort(c, errInvalidCursor)
+			return false
+		}
+		value, err := cursorValue(field.Type, page.AfterValues[i])
+		if err != nil {
+			problem.Abort(c, errInvalidCursor)
+			return false
+		}
+		page.AfterValues[i] = value
+	}
+	return true
+}
+
+// cursorValue converts a value decoded from a cursor, a string, bool or json.Number, to type t
+func cursorValue(t reflect.Type, value interface{}) (interface{}, error) {
+	switch v := value.(type) {
+	case string:
+		if t == reflect.TypeOf(time.Time{}) {
+			return time.Parse(time.RFC3339Nano, v)
+		}
+		if t.Kind() == reflect.String {
+			return v, nil
+		}
+	case bool:
+		if t.Kind() == reflect.Bool {
+			return v, nil
+		}
+	case json.Number:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return strconv.ParseInt(v.String(), 10, 64)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+			return strconv.ParseUint(v.String(), 10, 64)
+		case reflect.Float32, reflect.Float64:
+			return strconv.ParseFloat(v.String(), 64)
+		}
+	}
+	return nil, fmt.Errorf("cursor value %v does not fit %s", value, t)
+}
+
+// sortSpec spells an ordering the way ?sort does, for cursors to remember
+func sortSpec(sort []SortKey) string {
+	spec := make([]string, len(sort))
+	for i, key := range sort {
+		spec[i] = key.Column
+		if key.Desc {
+			spec[i] = "-" + key.Column
+		}
+	}
+	return strings.Join(spec, ",")
+}
+
+// paginate limits a query on T to page, ordered by the sort keys and then primary key;
+// it fetches one extra row to tell whether a next page exists
+func paginate[T any](db *gorm.DB, page Page) *gorm.DB {
+	keys := append(append([]SortKey{}, page.Sort...), SortKey{Column: repository.PrimaryKey[T]()})
+	if after := page.after(); after != nil {
+		clause, args := repository.KeysetCondition(keys, after)
+		db = db.Where(clause, args...)
+	}
+	return repository.OrderBy(db, keys).Limit(page.Limit + 1)
+}
+
+// after returns the sort values and then the primary key of the last row seen, or nil on the first page
+func (page Page) after() []interface{} {
+	if page.After == 0 {
+		return nil
+	}
+	return append(append([]interface{}{}, page.AfterValues...), page.After)
+}
+
+// nextPage trims the extra row fetched by paginate, sets the Link header and returns the page and next cursor
+func nextPage[T any](c *gin.Context, page Page, records []T) ([]T, string) {
+	if records == nil {
+		records = []T{}
+	}
+	if len(records) <= page.Limit {
+		return records, ""
+	}
+	records = records[:page.Limit]
+
+	last := records[len(records)-1]
+	after, err := strconv.ParseUint(fmt.Sprint(repository.PrimaryKeyValue(last)), 10, 64)
+	if err != nil {
+		return records, ""
+	}
+	next := cursor{After: after, Sort: sortSpec(page.Sort)}
+	for _, key := range page.Sort {
+		value, ok := repository.ColumnValue(last, key.Column)
+		if !ok {
+			return records, ""
+		}
+		next.Values = append(next.Values, value)
+	}
+	token := encodeCursor(next)
+
+	link := *c.Request.URL
+	query := link.Query()
+	query.Set("cursor", token)
+	query.Set("limit", strconv.Itoa(page.Limit))
+	link.RawQuery = query.Encode()
+	c.Header("Link", fmt.Sprintf(`<%s>; rel="next"`, link.RequestURI()))
+
+	return records, token
+}
+
+// respondPage writes a page of records as a PageResponse
+func respondPage[T any](c *gin.Context, page Page, records []T) {
+	records, next := nextPage(c, page, records)
+	c.JSON(http.StatusOK, PageResponse[T]{Data: records, NextCursor: next})
+}
+
+// respondQuery writes a page of records, reduced to the ?fields of query when it selects any
+func respondQuery[T any](c *gin.Context, page Page, query ListQuery, records []T) {
+	if len(query.Fields) == 0 {
+		respondPage(c, page, records)
+		return
+	}
+	records, next := nextPage(c, page, records)
+	rows, err := query.Project(records)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, PageResponse[map[string]interface{}]{Data: rows, NextCursor: next})
+}
+
+func encodeCursor(next cursor) string {
+	b, _ := json.Marshal(next)
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func decodeCursor(value string) (cursor, error) {
+	var decoded cursor
+	b, err := base64.RawURLEncoding.DecodeString(value)
+	if err != nil {
+		return decoded, err
+	}
+	// Numbers stay json.Number, so that large keys keep their precision until typeCursor converts them
+	decoder := json.NewDecoder(bytes.NewReader(b))
+	decoder.UseNumber()
+	err = decoder.Decode(&decoded)
+	return decoded, err
+}
@@ -1,21 +1,39 @@
 package handlers
 
 import (
-	"go-gin-postgres/database"
-	"net/http"
 	"time"
 
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+
 	"github.com/gin-gonic/gin"
 )
 
-func GetPaymentsByDate[T Model]() gin.HandlerFunc {
+// NewPaymentHandlers returns the payment handlers on repo. A customer can only add payments to their own tickets
+// in tickets, and a payment sent without created_at is created now
+func NewPaymentHandlers(repo repository.Repository[models.Payment], tickets repository.Repository[models.Ticket]) *Handlers[models.Payment] {
+	return NewHandlers(repo, Hooks[models.Payment]{
+		BeforeCreate: func(c *gin.Context, payment *models.Payment) error {
+			if payment.CreatedAtTime.IsZero() {
+				payment.CreatedAtTime = time.Now()
+			}
+			return requireOwnTicket(c, tickets, payment.TicketID)
+		},
+	})
+}
+
+// GetPaymentsByDate lists the payments in h created between :start_date and :end_date, YYYY-MM-DD,
+// with the same pagination, filtering, sorting and exports as List
+func GetPaymentsByDate[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		startDate, _ := time.Parse("2006-01-02", c.Param("start_date"))
-		endDate, _ := time.Parse("2006-01-02", c.Param("end_date"))
-		var records []T
-		db := database.GetDB()
-		db = db.Debug()
-		db.Where("created_at_time >= ? AND created_at_time <= ?", startDate, endDate).Find(&records)
-		c.JSON(http.StatusOK, records)
+		startDate, ok := timeParam(c, "start_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		endDate, ok := timeParam(c, "end_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		h.listWhere(c, between("created_at_time", startDate, endDate)...)
 	}
-}
\ No newline at end of file
+}
@@ -0,0 +1,247 @@
+package handlers
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// queryField is a column a list query may filter, sort or select on
+type queryField struct {
+	Name     string
+	Column   string
+	JSON     string
+	Type     reflect.Type
+	Nullable bool
+}
+
+// Filter is one clause of ?filter, e.g. amount>=10
+type Filter struct {
+	Field queryField
+	Op    string
+	Value interface{}
+}
+
+// ListQuery is the parsed ?filter, ?sort and ?fields of a list request
+type ListQuery struct {
+	Filters []Filter
+	Sort    []SortKey
+	Fields  []queryField
+}
+
+// filterOperators maps the operators of ?filter to SQL, longest first so that >= wins over >
+var filterOperators = []struct {
+	token string
+	sql   string
+}{
+	{">=", ">="},
+	{"<=", "<="},
+	{"!=", "<>"},
+	{">", ">"},
+	{"<", "<"},
+	{"=", "="},
+	{"~", "ILIKE"},
+}
+
+// likeEscaper escapes the LIKE wildcards in a ~ filter, so that % and _ only match themselves
+var likeEscaper = strings.NewReplacer(`\`, `\\`, `%`, `\%`, `_`, `\_`)
+
+// queryFields returns the allow-list of T keyed by both column and JSON name
+func queryFields[T any]() map[string]queryField {
+	fields := map[string]queryField{}
+	for _, field := range queryFieldList[T]() {
+		fields[field.Column] = field
+		fields[field.JSON] = field
+	}
+	return fields
+}
+
+// queryFieldList returns the allow-list of T in struct order.
+// Fields tagged json:"-" or query:"-" are never exposed to list queries or exports.
+func queryFieldList[T any]() []queryField {
+	var fields []queryField
+	t := reflect.TypeOf(new(T)).Elem()
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		jsonName := strings.Split(sf.Tag.Get("json"), ",")[0]
+		if !sf.IsExported() || jsonName == "-" || sf.Tag.Get("query") == "-" {
+			continue
+		}
+		if jsonName == "" {
+			jsonName = sf.Name
+		}
+		field := queryField{
+			Name:     sf.Name,
+			Column:   gorm.ToColumnName(sf.Name),
+			JSON:     jsonName,
+			Type:     sf.Type,
+			Nullable: sf.Type.Kind() == reflect.Ptr,
+		}
+		if field.Nullable {
+			field.Type = sf.Type.Elem()
+		}
+		fields = append(fields, field)
+	}
+	return fields
+}
+
+// parseListQuery reads ?filter, ?sort and ?fields against the allow-list of T, answering 400 on anything not in it
+func parseListQuery[T any](c *gin.Context) (ListQuery, bool) {
+	var query ListQuery
+	fields := queryFields[T]()
+
+	fail := func(format string, args ...interface{}) (ListQuery, bool) {
+		problem.Abort(c, problem.Validation(fmt.Sprintf(format, args...)))
+		return query, false
+	}
+
+	for _, clause := range splitList(c.Query("filter")) {
+		name, op, raw, ok := splitFilter(clause)
+		if !ok {
+			return fail("invalid filter %q", clause)
+		}
+		field, ok := fields[name]
+		if !ok {
+			return fail("unknown filter field %q", name)
+		}
+		if op == "ILIKE" {
+			if field.Type.Kind() != reflect.String {
+				return fail("~ only applies to text fields")
+			}
+			query.Filters = append(query.Filters, Filter{Field: field, Op: op, Value: "%" + likeEscaper.Replace(raw) + "%"})
+			continue
+		}
+		value, err := parseFilterValue(field.Type, raw)
+		if err != nil {
+			return fail("invalid value for %s: %v", name, err)
+		}
+		query.Filters = append(query.Filters, Filter{Field: field, Op: op, Value: value})
+	}
+
+	for _, name := range splitList(c.Query("sort")) {
+		key := SortKey{}
+		if strings.HasPrefix(name, "-") {
+			key.Desc = true
+			name = name[1:]
+		}
+		field, ok := fields[strings.TrimPrefix(name, "+")]
+		if !ok {
+			return fail("unknown sort field %q", name)
+		}
+		// NULLs have no place in a keyset, so nullable columns cannot be sorted on
+		if field.Nullable {
+			return fail("cannot sort on nullable field %q", name)
+		}
+		key.Column = field.Column
+		query.Sort = append(query.Sort, key)
+	}
+
+	for _, name := range splitList(c.Query("fields")) {
+		field, ok := fields[name]
+		if !ok {
+			return fail("unknown field %q", name)
+		}
+		query.Fields = append(query.Fields, field)
+	}
+
+	return query, true
+}
+
+// Query turns the filters, sort and column selection into a repository query within scope;
+// pk is the primary key column of the records queried
+func (q ListQuery) Query(scope repository.Scope, pk string) repository.Query {
+	for _, filter := range q.Filters {
+		scope.Where = append(scope.Where, repository.Condition{Column: filter.Field.Column, Op: filter.Op, Value: filter.Value})
+	}
+	query := repository.Query{Scope: scope, Order: q.Sort}
+	if len(q.Fields) > 0 {
+		// The primary key and sort columns are always read so that the next cursor can be built
+		query.Columns = []string{pk}
+		for _, key := range q.Sort {
+			query.Columns = append(query.Columns, key.Column)
+		}
+		for _, field := range q.Fields {
+			query.Columns = append(query.Columns, field.Column)
+		}
+	}
+	return query
+}
+
+// Project reduces records to the JSON keys selected with ?fields
+func (q ListQuery) Project(records interface{}) ([]map[string]interface{}, error) {
+	b, err := json.Marshal(records)
+	if err != nil {
+		return nil, err
+	}
+	var rows []map[string]interface{}
+	if err := json.Unmarshal(b, &rows); err != nil {
+		return nil, err
+	}
+	projected := make([]map[string]interface{}, len(rows))
+	for i, row := range rows {
+		projected[i] = map[string]interface{}{}
+		for _, field := range q.Fields {
+			projected[i][field.JSON] = row[field.JSON]
+		}
+	}
+	return projected, nil
+}
+
+// splitFilter splits "amount>=10" into its field, SQL operator and raw value
+func splitFilter(clause string) (string, string, string, bool) {
+	i := strings.IndexAny(clause, "<>=!~")
+	if i <= 0 {
+		return "", "", "", false
+	}
+	for _, op := range filterOperators {
+		if strings.HasPrefix(clause[i:], op.token) {
+			return strings.TrimSpace(clause[:i]), op.sql, strings.TrimSpace(clause[i+len(op.token):]), true
+		}
+	}
+	return "", "", "", false
+}
+
+// parseFilterValue converts raw to the Go type of the column it is compared with
+func parseFilterValue(t reflect.Type, raw string) (interface{}, error) {
+	if t == reflect.TypeOf(time.Time{}) {
+		for _, layout := range []string{time.RFC3339, "2006-01-02 15:04:05", "2006-01-02"} {
+			if value, err := time.Parse(layout, raw); err == nil {
+				return value, nil
+			}
+		}
+		return nil, fmt.Errorf("use YYYY-MM-DD, YYYY-MM-DD HH:MM:SS or RFC 3339")
+	}
+	switch t.Kind() {
+	case reflect.String:
+		return raw, nil
+	case reflect.Bool:
+		return strconv.ParseBool(raw)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return strconv.ParseInt(raw, 10, 64)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return strconv.ParseUint(raw, 10, 64)
+	case reflect.Float32, reflect.Float64:
+		return strconv.ParseFloat(raw, 64)
+	}
+	return nil, fmt.Errorf("field cannot be filtered")
+}
+
+// splitList splits a comma-separated query parameter, dropping empty items
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
@@ -0,0 +1,173 @@
+package handlers_test
+
+import (
+	"encoding/base64"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"slices"
+	"testing"
+
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/models"
+
+	"github.com/gin-gonic/gin"
+)
+
+// rawCursor encodes the JSON of a cursor the way the server does, to forge cursors with
+func rawCursor(json string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(json))
+}
+
+// seedTickets creates tickets of users 2, 2 and 3 on the first, second and third of May 2024
+func seedTickets(t *testing.T) *gin.Engine {
+	t.Helper()
+	router, _ := ticketServer(t)
+	for _, body := range []string{
+		`{"user_id": 2, "date_created": "2024-05-01T10:00:00Z"}`,
+		`{"user_id": 2, "date_created": "2024-05-02T10:00:00Z", "date_paid": "2024-05-02T11:00:00Z"}`,
+		`{"user_id": 3, "date_created": "2024-05-03T10:00:00Z"}`,
+	} {
+		if w := send(t, router, "staff", http.MethodPost, "/tickets", body, nil); w.Code != http.StatusOK {
+			t.Fatalf("create answered %d: %s", w.Code, w.Body)
+		}
+	}
+	return router
+}
+
+// list lists the tickets with query as staff, decoding the answer into out when given
+func list(t *testing.T, router *gin.Engine, query url.Values, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	return send(t, router, "staff", http.MethodGet, "/tickets?"+query.Encode(), "", out)
+}
+
+// ticketIDs returns the IDs of tickets in order
+func ticketIDs(tickets []models.Ticket) []uint {
+	ids := []uint{}
+	for _, ticket := range tickets {
+		ids = append(ids, ticket.TicketID)
+	}
+	return ids
+}
+
+func TestListQueryParameters(t *testing.T) {
+	router := seedTickets(t)
+
+	for _, tc := range []struct {
+		name   string
+		query  url.Values
+		status int
+		// tickets are the IDs listed in order, when the request succeeds
+		tickets []uint
+	}{
+		{"no parameters", url.Values{}, http.StatusOK, []uint{1, 2, 3}},
+		{"equal", url.Values{"filter": {"user_id=2"}}, http.StatusOK, []uint{1, 2}},
+		{"not equal", url.Values{"filter": {"user_id!=2"}}, http.StatusOK, []uint{3}},
+		{"greater or equal", url.Values{"filter": {"date_created>=2024-05-02"}}, http.StatusOK, []uint{2, 3}},
+		{"less than a time", url.Values{"filter": {"date_created<2024-05-02 10:00:00"}}, http.StatusOK, []uint{1}},
+		{"several filters", url.Values{"filter": {"user_id=2, date_created>=2024-05-02"}}, http.StatusOK, []uint{2}},
+		{"column name", url.Values{"filter": {"UserID=3"}}, http.StatusBadRequest, nil},
+		{"sort descending", url.Values{"sort": {"-date_created"}}, http.StatusOK, []uint{3, 2, 1}},
+		{"sort by two fields", url.Values{"sort": {"-user_id,date_created"}}, http.StatusOK, []uint{3, 1, 2}},
+		{"unknown filter field", url.Values{"filter": {"owner=2"}}, http.StatusBadRequest, nil},
+		{"no field", url.Values{"filter": {"=2"}}, http.StatusBadRequest, nil},
+		{"no operator", url.Values{"filter": {"user_id 2"}}, http.StatusBadRequest, nil},
+		{"mistyped value", url.Values{"filter": {"user_id=two"}}, http.StatusBadRequest, nil},
+		{"mistyped time", url.Values{"filter": {"date_created>=yesterday"}}, http.StatusBadRequest, nil},
+		{"like on a number", url.Values{"filter": {"user_id~2"}}, http.StatusBadRequest, nil},
+		{"SQL in the value", url.Values{"filter": {"user_id=2 OR 1=1"}}, http.StatusBadRequest, nil},
+		{"statement in the value", url.Values{"filter": {"user_id=2; DROP TABLE tickets"}}, http.StatusBadRequest, nil},
+		{"SQL in the field", url.Values{"filter": {"user_id) OR (1=1"}}, http.StatusBadRequest, nil},
+		{"tautology", url.Values{"filter": {"1=1"}}, http.StatusBadRequest, nil},
+		{"unknown sort field", url.Values{"sort": {"owner"}}, http.StatusBadRequest, nil},
+		{"SQL in the sort", url.Values{"sort": {"user_id; DROP TABLE tickets"}}, http.StatusBadRequest, nil},
+		{"sort on a nullable field", url.Values{"sort": {"date_paid"}}, http.StatusBadRequest, nil},
+		{"unknown field", url.Values{"fields": {"owner"}}, http.StatusBadRequest, nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var page handlers.PageResponse[models.Ticket]
+			w := list(t, router, tc.query, &page)
+			if w.Code != tc.status {
+				t.Fatalf("answered %d, want %d: %s", w.Code, tc.status, w.Body)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+			if got := ticketIDs(page.Data); !slices.Equal(got, tc.tickets) {
+				t.Errorf("listed %v, want %v", got, tc.tickets)
+			}
+		})
+	}
+}
+
+func TestLikeFilterEscapesWildcards(t *testing.T) {
+	router := orderServer(t)
+	for _, item := range []string{"50% off", "500 off", "Tea_Latte", "Tea Latte"} {
+		send(t, router, "2", http.MethodPost, "/orders", `{"ticket_id": 1, "menu_item": "`+item+`", "quantity": 1, "price": 1}`, nil)
+	}
+
+	for filter, want := range map[string]string{
+		"menu_item~50%":    "50% off",
+		"menu_item~a_l":    "Tea_Latte",
+		"menu_item~TEA_LA": "Tea_Latte",
+	} {
+		var page handlers.PageResponse[models.Order]
+		w := send(t, router, "2", http.MethodGet, "/orders?"+url.Values{"filter": {filter}}.Encode(), "", &page)
+		if w.Code != http.StatusOK || len(page.Data) != 1 || page.Data[0].MenuItem != want {
+			t.Errorf("%s answered %d %+v, want only %q", filter, w.Code, page.Data, want)
+		}
+	}
+}
+
+func TestCursors(t *testing.T) {
+	router := seedTickets(t)
+
+	// Walk the pages one ticket at a time
+	query := url.Values{"sort": {"-date_created"}, "limit": {"1"}}
+	var walked []uint
+	var cursors []string
+	for {
+		var page handlers.PageResponse[models.Ticket]
+		if w := list(t, router, query, &page); w.Code != http.StatusOK {
+			t.Fatalf("answered %d: %s", w.Code, w.Body)
+		}
+		walked = append(walked, ticketIDs(page.Data)...)
+		if page.NextCursor == "" {
+			break
+		}
+		cursors = append(cursors, page.NextCursor)
+		query.Set("cursor", page.NextCursor)
+	}
+	if !slices.Equal(walked, []uint{3, 2, 1}) {
+		t.Fatalf("walked %v, want [3 2 1]", walked)
+	}
+
+	for _, tc := range []struct {
+		name  string
+		query url.Values
+	}{
+		{"not base64", url.Values{"sort": {"-date_created"}, "cursor": {"not a cursor!"}}},
+		{"not JSON", url.Values{"sort": {"-date_created"}, "cursor": {rawCursor("after=3")}}},
+		{"another sort", url.Values{"sort": {"date_created"}, "cursor": {cursors[0]}}},
+		{"no sort", url.Values{"cursor": {cursors[0]}}},
+		{"sort swapped in", url.Values{"sort": {"-user_id"}, "cursor": {rawCursor(`{"after":3,"sort":"-user_id","values":["2024-05-03T10:00:00Z"]}`)}}},
+		{"values missing", url.Values{"sort": {"-date_created"}, "cursor": {rawCursor(`{"after":3,"sort":"-date_created"}`)}}},
+		{"too many values", url.Values{"sort": {"-date_created"}, "cursor": {rawCursor(`{"after":3,"sort":"-date_created","values":["2024-05-03T10:00:00Z",1]}`)}}},
+		{"value of another type", url.Values{"sort": {"-date_created"}, "cursor": {rawCursor(`{"after":3,"sort":"-date_created","values":[20240503]}`)}}},
+		{"SQL as the value", url.Values{"sort": {"-date_created"}, "cursor": {rawCursor(`{"after":3,"sort":"-date_created","values":["now()) OR (1=1"]}`)}}},
+		{"negative key", url.Values{"cursor": {rawCursor(`{"after":-1}`)}}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if w := list(t, router, tc.query, nil); w.Code != http.StatusBadRequest {
+				t.Errorf("answered %d, want 400: %s", w.Code, w.Body)
+			}
+		})
+	}
+
+	// A well-formed cursor for the same sort picks up where it points
+	var page handlers.PageResponse[models.Ticket]
+	list(t, router, url.Values{"sort": {"-date_created"}, "cursor": {rawCursor(`{"after":2,"sort":"-date_created","values":["2024-05-02T10:00:00Z"]}`)}}, &page)
+	if got := ticketIDs(page.Data); !slices.Equal(got, []uint{1}) {
+		t.Errorf("listed %v after ticket 2, want [1]", got)
+	}
+}
@@ -0,0 +1,268 @@
+package handlers
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Handlers serve the CRUD routes of one model from a repository, customized by hooks
+type Handlers[T Model] struct {
+	repo  repository.Repository[T]
+	hooks Hooks[T]
+}
+
+// NewHandlers returns the handlers of T on repo
+func NewHandlers[T Model](repo repository.Repository[T], hooks Hooks[T]) *Handlers[T] {
+	return &Handlers[T]{repo: repo, hooks: hooks}
+}
+
+// List answers a filtered, sorted page or export of the caller's records
+func (h *Handlers[T]) List() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		h.list(c, h.scope(c))
+	}
+}
+
+// Trash lists the caller's soft-deleted records, taking the same query parameters as List
+func (h *Handlers[T]) Trash() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		scope := h.scope(c)
+		scope.Trashed = true
+		h.list(c, scope)
+	}
+}
+
+// Create inserts the record in the request body as version 1
+func (h *Handlers[T]) Create() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionCreate) {
+			return
+		}
+		var record T
+		if err := c.ShouldBindJSON(&record); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		h.create(c, record)
+	}
+}
+
+// Get answers the record named by :id with its ETag, or 304 when If-None-Match names its version
+func (h *Handlers[T]) Get() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionRead) {
+			return
+		}
+		record, ok := h.find(c)
+		if !ok {
+			return
+		}
+		version := *versionOf(&record)
+		c.Header("ETag", etag(version))
+		if ifNoneMatch(c, version) {
+			c.Status(http.StatusNotModified)
+			return
+		}
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// Replace replaces a record with the request body; a stale If-Match answers 412
+func (h *Handlers[T]) Replace() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionUpdate) {
+			return
+		}
+		body, err := c.GetRawData()
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		h.update(c, func(record *T) error {
+			return binding.JSON.BindBody(body, record)
+		})
+	}
+}
+
+// Patch applies a JSON Merge Patch or JSON Patch to a record; a stale If-Match answers 412
+func (h *Handlers[T]) Patch() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionUpdate) {
+			return
+		}
+		body, err := c.GetRawData()
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		h.update(c, func(record *T) error {
+			return applyPatch(c.ContentType(), body, record)
+		})
+	}
+}
+
+// Delete moves a record to the trash; a stale If-Match answers 412
+func (h *Handlers[T]) Delete() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionDelete) {
+			return
+		}
+		record, ok := h.find(c)
+		if !ok {
+			return
+		}
+		version := *versionOf(&record)
+		if !ifMatch(c, version) {
+			problem.Abort(c, errPreconditionFailed)
+			return
+		}
+		if err := h.hooks.beforeDelete(c, record); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		// The version check catches an update that lands between the read and the delete
+		err := h.repo.Delete(c.Request.Context(), repository.PrimaryKeyValue(record), version, h.scope(c))
+		if err != nil {
+			problem.Abort(c, writeError(err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": resourceName[T]() + " deleted"})
+	}
+}
+
+// scope limits the records the caller sees to their own, unless they are admin or staff
+func (h *Handlers[T]) scope(c *gin.Context) repository.Scope {
+	return repository.Scope{Owner: ownerOf(c)}
+}
+
+// list answers a filtered, sorted page or export of the records in scope
+func (h *Handlers[T]) list(c *gin.Context, scope repository.Scope) {
+	if !authorize[T](c, ActionList) {
+		return
+	}
+	query, ok := parseListQuery[T](c)
+	if !ok {
+		return
+	}
+	page, ok := parsePage(c, query.Sort...)
+	if !ok || !typeCursor[T](c, &page) {
+		return
+	}
+	q := query.Query(scope, repository.PrimaryKey[T]())
+	if format := exportFormat(c); format != "" {
+		streamRecords(c, h.repo, q, format, query)
+		return
+	}
+	q.After = page.after()
+	q.Limit = page.Limit + 1
+	records, err := h.repo.List(c.Request.Context(), q)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	respondQuery(c, page, query, records)
+}
+
+// listWhere answers a page of the records in the caller's scope that also match conditions
+func (h *Handlers[T]) listWhere(c *gin.Context, conditions ...repository.Condition) {
+	scope := h.scope(c)
+	scope.Where = append(scope.Where, conditions...)
+	h.list(c, scope)
+}
+
+// between matches the records whose column lies from start to end, both included
+func between(column string, start, end time.Time) []repository.Condition {
+	return []repository.Condition{{Column: column, Op: ">=", Value: start}, {Column: column, Op: "<=", Value: end}}
+}
+
+// find loads the record named by :id among those the caller can see.
+// A record that is missing, deleted or someone else's answers 404
+func (h *Handlers[T]) find(c *gin.Context) (T, bool) {
+	var record T
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Abort(c, problem.NotFound(resourceName[T]()+" not found"))
+		return record, false
+	}
+	record, err = h.repo.Get(c.Request.Context(), id, h.scope(c))
+	if err != nil {
+		problem.Abort(c, lookupError[T](err))
+		return record, false
+	}
+	return record, true
+}
+
+// create runs the create hook on a decoded record, inserts it as version 1 and answers with it
+func (h *Handlers[T]) create(c *gin.Context, record T) {
+	if err := h.hooks.beforeCreate(c, &record); err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	*versionOf(&record) = 1
+	if err := h.repo.Create(c.Request.Context(), &record); err != nil {
+		problem.Abort(c, writeError(err))
+		return
+	}
+	c.Header("ETag", etag(*versionOf(&record)))
+	c.JSON(http.StatusOK, record)
+}
+
+// update loads the record named by :id, checks If-Match against its version and saves the change to it
+func (h *Handlers[T]) update(c *gin.Context, change func(record *T) error) {
+	original, ok := h.find(c)
+	if !ok {
+		return
+	}
+	record, err := h.save(c, h.repo, original, func(version uint) bool {
+		return ifMatch(c, version)
+	}, change)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	h.hooks.afterUpdate(c, original, record)
+	c.Header("ETag", etag(*versionOf(&record)))
+	c.JSON(http.StatusOK, record)
+}
+
+// save lets change edit a copy of original, runs the update hook and writes the copy to repo as the
+// next version, provided expect accepts the version it was read at. Callers never change the primary
+// key or version, nor the fields the caller may not set
+func (h *Handlers[T]) save(c *gin.Context, repo repository.Repository[T], original T, expect func(version uint) bool, change func(record *T) error) (T, error) {
+	record := original
+	version := *versionOf(&record)
+	if !expect(version) {
+		return record, errPreconditionFailed
+	}
+	if err := change(&record); err != nil {
+		return record, err
+	}
+	protectFields(c, original, &record)
+	if err := h.hooks.beforeUpdate(c, original, &record); err != nil {
+		return record, err
+	}
+	// The version check catches an update that lands between the read and the write
+	err := repo.Update(c.Request.Context(), repository.PrimaryKeyValue(original), &record, version)
+	return record, writeError(err)
+}
+
+// writeError reports a record that changed under a write as a failed precondition,
+// and a record the model rejected as invalid
+func writeError(err error) error {
+	switch {
+	case errors.Is(err, repository.ErrVersionConflict):
+		return errPreconditionFailed
+	case errors.Is(err, repository.ErrInvalid):
+		return problem.Validation(strings.TrimPrefix(err.Error(), repository.ErrInvalid.Error()+": "))
+	}
+	return err
+}
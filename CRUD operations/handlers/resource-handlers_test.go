@@ -0,0 +1,148 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ticketServer serves the ticket routes from an in-memory repository to whoever the X-User header names:
+// "staff", or a customer by ID
+func ticketServer(t *testing.T) (*gin.Engine, *repository.Memory[models.Ticket]) {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	repo := repository.NewMemory(func(ticket models.Ticket, userID uint) bool {
+		return ticket.UserID == userID
+	})
+	tickets := handlers.NewTicketHandlers(repo)
+
+	router := gin.New()
+	router.Use(middleware.ErrorMiddleware())
+	group := router.Group("/", func(c *gin.Context) {
+		switch user := c.GetHeader("X-User"); user {
+		case "staff":
+			auth.SetCurrentUser(c, auth.Principal{UserID: 1, Roles: []string{auth.RoleStaff}})
+		case "2", "3":
+			auth.SetCurrentUser(c, auth.Principal{UserID: uint(user[0] - '0'), Roles: []string{auth.RoleCustomer}})
+		}
+	})
+	resource := handlers.RegisterResource(group, "/tickets", tickets)
+	resource.GET("/payment/:status", handlers.GetTicketsByPaymentStatus(tickets))
+	return router, repo
+}
+
+// send performs a request as user and decodes the JSON answer into out, when given
+func send(t *testing.T, router *gin.Engine, user, method, path, body string, out interface{}) *httptest.ResponseRecorder {
+	t.Helper()
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("X-User", user)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if out != nil {
+		if err := json.Unmarshal(w.Body.Bytes(), out); err != nil {
+			t.Fatalf("%s %s: %v in %s", method, path, err, w.Body)
+		}
+	}
+	return w
+}
+
+func TestTicketLifecycle(t *testing.T) {
+	router, _ := ticketServer(t)
+
+	// A customer's new ticket is their own and unpaid, whatever the body says
+	var ticket models.Ticket
+	w := send(t, router, "2", http.MethodPost, "/tickets", `{"user_id": 3, "date_paid": "2024-01-01T00:00:00Z"}`, &ticket)
+	if w.Code != http.StatusOK || ticket.UserID != 2 || ticket.DatePaid != nil || ticket.Version != 1 {
+		t.Fatalf("create answered %d %+v", w.Code, ticket)
+	}
+
+	if w := send(t, router, "3", http.MethodGet, "/tickets/1", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("another customer's read answered %d, want 404", w.Code)
+	}
+
+	req := httptest.NewRequest(http.MethodDelete, "/tickets/1", nil)
+	req.Header.Set("X-User", "staff")
+	req.Header.Set("If-Match", `"7"`)
+	w = httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	if w.Code != http.StatusPreconditionFailed {
+		t.Errorf("stale delete answered %d, want 412", w.Code)
+	}
+
+	if w := send(t, router, "staff", http.MethodDelete, "/tickets/1", "", nil); w.Code != http.StatusOK {
+		t.Fatalf("delete answered %d: %s", w.Code, w.Body)
+	}
+	var trash handlers.PageResponse[models.Ticket]
+	send(t, router, "2", http.MethodGet, "/tickets/trash", "", &trash)
+	if len(trash.Data) != 1 {
+		t.Errorf("trash has %d tickets, want 1", len(trash.Data))
+	}
+
+	var restored models.Ticket
+	w = send(t, router, "staff", http.MethodPost, "/tickets/1/restore", "", &restored)
+	if w.Code != http.StatusOK || restored.DeletedAt != nil || restored.Version != 2 {
+		t.Fatalf("restore answered %d %+v", w.Code, restored)
+	}
+	if w := send(t, router, "staff", http.MethodPost, "/tickets/1/restore", "", nil); w.Code != http.StatusNotFound {
+		t.Errorf("restoring a live ticket answered %d, want 404", w.Code)
+	}
+}
+
+func TestBulkCreateAtomicRollsBack(t *testing.T) {
+	router, repo := ticketServer(t)
+
+	var response handlers.BulkResponse
+	w := send(t, router, "staff", http.MethodPost, "/tickets/bulk", `[{"user_id": 2}, {"user_id": "two"}]`, &response)
+	if w.Code != http.StatusUnprocessableEntity || response.Failed != 1 {
+		t.Fatalf("bulk create answered %d %+v", w.Code, response)
+	}
+	if stored, _ := repo.List(context.Background(), repository.Query{}); len(stored) != 0 {
+		t.Errorf("an aborted bulk create stored %d tickets", len(stored))
+	}
+
+	w = send(t, router, "staff", http.MethodPost, "/tickets/bulk?mode=best_effort", `[{"user_id": 2}, {"user_id": "two"}]`, &response)
+	if w.Code != http.StatusMultiStatus {
+		t.Fatalf("best-effort bulk create answered %d", w.Code)
+	}
+	if stored, _ := repo.List(context.Background(), repository.Query{}); len(stored) != 1 {
+		t.Errorf("a best-effort bulk create stored %d tickets, want 1", len(stored))
+	}
+}
+
+func TestGetTicketsByPaymentStatus(t *testing.T) {
+	router, _ := ticketServer(t)
+	send(t, router, "staff", http.MethodPost, "/tickets", `{"user_id": 2, "date_paid": "2024-01-01T00:00:00Z"}`, nil)
+	send(t, router, "staff", http.MethodPost, "/tickets", `{"user_id": 2}`, nil)
+	send(t, router, "staff", http.MethodPost, "/tickets", `{"user_id": 3}`, nil)
+
+	for _, tc := range []struct {
+		user, status string
+		want         int
+	}{
+		{"staff", "paid", 1},
+		{"staff", "unpaid", 2},
+		{"2", "unpaid", 1},
+	} {
+		var page handlers.PageResponse[models.Ticket]
+		send(t, router, tc.user, http.MethodGet, "/tickets/payment/"+tc.status, "", &page)
+		if len(page.Data) != tc.want {
+			t.Errorf("%s sees %d %s tickets, want %d", tc.user, len(page.Data), tc.status, tc.want)
+		}
+	}
+
+	if w := send(t, router, "staff", http.MethodGet, "/tickets/payment/later", "", nil); w.Code != http.StatusBadRequest {
+		t.Errorf("an unknown status answered %d, want 400", w.Code)
+	}
+}
@@ -0,0 +1,92 @@
+package handlers
+
+import (
+	"go-gin-postgres/auth"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Hooks customize the generic handlers for one model. Every field is optional
+type Hooks[T Model] struct {
+	// Middleware runs before every route of the resource
+	Middleware []gin.HandlerFunc
+	// BeforeCreate runs on a decoded record before Create or BulkCreate inserts it
+	BeforeCreate func(c *gin.Context, record *T) error
+	// BeforeUpdate runs on a changed record before Replace, Patch or BulkUpdate saves it
+	BeforeUpdate func(c *gin.Context, original T, record *T) error
+	// AfterUpdate runs once Replace, Patch or BulkUpdate has stored a changed record; it cannot fail the request
+	AfterUpdate func(c *gin.Context, original T, record T)
+	// BeforeDelete runs before Delete or BulkDelete moves a record to the trash
+	BeforeDelete func(c *gin.Context, record T) error
+}
+
+// RegisterResource mounts the full set of routes of h under path:
+//
+//	GET    path             list, filter, sort and export
+//	POST   path             create
+//	GET    path/:id         read
+//	PUT    path/:id         replace
+//	PATCH  path/:id         merge patch or JSON patch
+//	DELETE path/:id         move to the trash
+//	GET    path/trash       list the trash
+//	POST   path/:id/restore restore from the trash
+//	DELETE path/trash/:id   purge from the trash (admin)
+//	POST   path/bulk        bulk create
+//	PUT    path/bulk        bulk update
+//	DELETE path/bulk        bulk delete
+//
+// It returns the resource's group so that callers can mount routes of their own next to these.
+// RegisterResource is meant to be called while the router is set up, before it serves requests
+func RegisterResource[T Model](group *gin.RouterGroup, path string, h *Handlers[T]) *gin.RouterGroup {
+	resource := group.Group(path, h.hooks.Middleware...)
+	resource.GET("", h.List())
+	resource.POST("", h.Create())
+	resource.GET("/:id", h.Get())
+	resource.PUT("/:id", h.Replace())
+	resource.PATCH("/:id", h.Patch())
+	resource.DELETE("/:id", h.Delete())
+	resource.GET("/trash", h.Trash())
+	resource.POST("/:id/restore", h.Restore())
+	resource.DELETE("/trash/:id", auth.Require(auth.RoleAdmin), h.Purge())
+	resource.POST("/bulk", h.BulkCreate())
+	resource.PUT("/bulk", h.BulkUpdate())
+	resource.DELETE("/bulk", h.BulkDelete())
+	return resource
+}
+
+func (h Hooks[T]) beforeCreate(c *gin.Context, record *T) error {
+	if h.BeforeCreate == nil {
+		return nil
+	}
+	return h.BeforeCreate(c, record)
+}
+
+func (h Hooks[T]) beforeUpdate(c *gin.Context, original T, record *T) error {
+	if h.BeforeUpdate == nil {
+		return nil
+	}
+	return h.BeforeUpdate(c, original, record)
+}
+
+func (h Hooks[T]) afterUpdate(c *gin.Context, original T, record T) {
+	if h.AfterUpdate != nil {
+		h.AfterUpdate(c, original, record)
+	}
+}
+
+func (h Hooks[T]) beforeDelete(c *gin.Context, record T) error {
+	if h.BeforeDelete == nil {
+		return nil
+	}
+	return h.BeforeDelete(c, record)
+}
+
+// RegisterNested mounts routes that list and create the records of children under the records of parents,
+// mounted at parent and linked by the foreignKey column of the children:
+//
+//	GET  parent/:id/path  list the parent's children
+//	POST parent/:id/path  create a child of the parent
+func RegisterNested[P, C Model](parent *gin.RouterGroup, path, foreignKey string, parents *Handlers[P], children *Handlers[C]) {
+	parent.GET("/:id"+path, ListChildren(parents, children, foreignKey))
+	parent.POST("/:id"+path, CreateChild(parents, children, foreignKey))
+}
@@ -1,11 +1,15 @@
 package handlers
 
 import (
+	"context"
 	"net/http"
+	"strconv"
 	"time"
 
-	"go-gin-postgres/database"
+	"go-gin-postgres/auth"
 	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
 
 	"github.com/gin-gonic/gin"
 	"github.com/sirupsen/logrus"
@@ -25,230 +29,203 @@ type PaymentModel interface {
 	models.Payment
 }
 
+// NewTicketHandlers returns the ticket handlers on repo. A customer's new ticket is always their own and unpaid,
+// and a ticket sent without date_created is created now
+func NewTicketHandlers(repo repository.Repository[models.Ticket]) *Handlers[models.Ticket] {
+	return NewHandlers(repo, Hooks[models.Ticket]{
+		BeforeCreate: func(c *gin.Context, ticket *models.Ticket) error {
+			if principal, _ := auth.CurrentUser(c); !principal.HasAnyRole(auth.RoleAdmin, auth.RoleStaff) {
+				ticket.UserID = principal.UserID
+				ticket.DatePaid = nil
+			}
+			if ticket.DateCreated.IsZero() {
+				ticket.DateCreated = time.Now()
+			}
+			return nil
+		},
+	})
+}
+
 
-func GetTicketsByDate[T Model]() gin.HandlerFunc {
+// GetTicketsByDate lists the tickets in h created between :start_date and :end_date, YYYY-MM-DD,
+// with the same pagination, filtering, sorting and exports as List
+func GetTicketsByDate[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		startDate, _ := time.Parse("2006-01-02", c.Param("start_date"))
-		endDate, _ := time.Parse("2006-01-02", c.Param("end_date"))
-		var records []T
-		db := database.GetDB()
-		db = db.Debug()
-		db.Where("date_created >= ? AND date_created <= ?", startDate, endDate).Find(&records)
-		c.JSON(http.StatusOK, records)
+		startDate, ok := timeParam(c, "start_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		endDate, ok := timeParam(c, "end_date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
+		h.listWhere(c, between("date_created", startDate, endDate)...)
 	}
 }
 
-func GetTicketsByDateTime[T Model]() gin.HandlerFunc {
+// GetTicketsByDateTime lists the tickets in h created between :start_date and :end_date, YYYY-MM-DD HH:MM:SS
+func GetTicketsByDateTime[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		startDate, err1 := time.Parse("2006-01-02 15:04:05", c.Param("start_date"))
-		endDate, err2 := time.Parse("2006-01-02 15:04:05", c.Param("end_date"))
-		
-		if err1 != nil || err2 != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD HH:MM:SS"})
+		startDate, ok := timeParam(c, "start_date", "2006-01-02 15:04:05", "YYYY-MM-DD HH:MM:SS")
+		if !ok {
 			return
 		}
-		
-		var records []T
-		db := database.GetDB().Debug()
-		db.Where("date_created >= ? AND date_created <= ?", startDate, endDate).Find(&records)
-		c.JSON(http.StatusOK, records)
+		endDate, ok := timeParam(c, "end_date", "2006-01-02 15:04:05", "YYYY-MM-DD HH:MM:SS")
+		if !ok {
+			return
+		}
+		h.listWhere(c, between("date_created", startDate, endDate)...)
 	}
 }
 
-
-
-func GetTicketsByUserId[T Model]() gin.HandlerFunc {
+// GetTicketsByUserId lists the tickets in h of the user :user_id
+func GetTicketsByUserId[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var records []T
-		db := database.GetDB()
-		db.Where("user_id = ?", c.Param("user_id")).Find(&records)
-		c.JSON(http.StatusOK, records)
+		userID, err := strconv.ParseUint(c.Param("user_id"), 10, 64)
+		if err != nil {
+			problem.Abort(c, problem.Validation("user_id must be a positive integer",
+				problem.FieldError{Field: "user_id", Reason: "numeric"}))
+			return
+		}
+		h.listWhere(c, repository.Condition{Column: "user_id", Op: "=", Value: userID})
 	}
 }
 
-func GetTicketsByPaymentStatus[T Model]() gin.HandlerFunc {
+// GetTicketsByPaymentStatus lists the tickets in h that are :status paid or unpaid
+func GetTicketsByPaymentStatus[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		status := c.Param("status")
-		var records []T
-		db := database.GetDB()
-		if status == "paid" {
-			db.Where("date_paid IS NOT NULL").Find(&records)
-		} else if status == "unpaid" {
-			db.Where("date_paid IS NULL").Find(&records)
-		} else {
-			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid status"})
-			return
+		switch c.Param("status") {
+		case "paid":
+			h.listWhere(c, repository.Condition{Column: "date_paid", Op: "IS NOT NULL"})
+		case "unpaid":
+			h.listWhere(c, repository.Condition{Column: "date_paid", Op: "IS NULL"})
+		default:
+			problem.Abort(c, problem.Validation("invalid status, use paid or unpaid",
+				problem.FieldError{Field: "status", Reason: "oneof paid unpaid"}))
 		}
-		c.JSON(http.StatusOK, records)
 	}
 }
 
+// relatedRecords are the tickets of a page with the users, orders and payments that belong to them
+type relatedRecords[T, U, O, P any] struct {
+	Users      []U    `json:"users"`
+	Tickets    []T    `json:"tickets"`
+	Orders     []O    `json:"orders"`
+	Payments   []P    `json:"payments"`
+	NextCursor string `json:"next_cursor,omitempty"`
+}
 
+// listRelated answers a page of the caller's tickets matching conditions, with their users, orders and payments,
+// or streams all of them when an export is asked for
+func listRelated[T TicketModel, U Model, O OrderModel, P PaymentModel](c *gin.Context, tickets *Handlers[T], users *Handlers[U], orders *Handlers[O], payments *Handlers[P], conditions ...repository.Condition) {
+	scope := tickets.scope(c)
+	scope.Where = append(scope.Where, conditions...)
+	if format := exportFormat(c); format != "" {
+		streamRelated(c, tickets, users, orders, payments, scope, format)
+		return
+	}
+	page, ok := parsePage(c)
+	if !ok {
+		return
+	}
+	found, err := tickets.repo.List(c.Request.Context(), repository.Query{Scope: scope, After: page.after(), Limit: page.Limit + 1})
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	found, nextCursor := nextPage(c, page, found)
 
+	response, err := findRelated(c.Request.Context(), users, orders, payments, found)
+	if err != nil {
+		problem.Abort(c, err)
+		return
+	}
+	response.NextCursor = nextCursor
+	c.JSON(http.StatusOK, response)
+}
 
-func GetRecordsByTicketDateCreated[T TicketModel, U Model, O OrderModel, P PaymentModel]() gin.HandlerFunc {
-    return func(c *gin.Context) {
+// findRelated returns found with the users, orders and payments that belong to them
+func findRelated[T TicketModel, U Model, O OrderModel, P PaymentModel](ctx context.Context, users *Handlers[U], orders *Handlers[O], payments *Handlers[P], found []T) (relatedRecords[T, U, O, P], error) {
+	response := relatedRecords[T, U, O, P]{Users: []U{}, Tickets: found, Orders: []O{}, Payments: []P{}}
+	if len(found) == 0 {
+		return response, nil
+	}
+	var userIDs []uint
+	var ticketIDs []uint
+	for _, ticket := range found {
+		userIDs = append(userIDs, ticket.GetUserID())
+		ticketIDs = append(ticketIDs, ticket.GetTicketID())
+	}
+
+	// Find the users, orders and payments related to the tickets
+	var err error
+	if response.Users, err = users.repo.List(ctx, repository.Query{Scope: repository.Scope{Where: []repository.Condition{
+		{Column: repository.PrimaryKey[U](), Op: "IN", Value: userIDs}}}}); err != nil {
+		return response, err
+	}
+	if response.Orders, err = orders.repo.List(ctx, repository.Query{Scope: repository.Scope{Where: []repository.Condition{
+		{Column: "ticket_id", Op: "IN", Value: ticketIDs}}}}); err != nil {
+		return response, err
+	}
+	if response.Payments, err = payments.repo.List(ctx, repository.Query{Scope: repository.Scope{Where: []repository.Condition{
+		{Column: "ticket_id", Op: "IN", Value: ticketIDs}}}}); err != nil {
+		return response, err
+	}
+	return response, nil
+}
 
+// GetRecordsByTicketDateCreated lists a page of the tickets created on :date_created, YYYY-MM-DD,
+// with their users, orders and payments
+func GetRecordsByTicketDateCreated[T TicketModel, U Model, O OrderModel, P PaymentModel](tickets *Handlers[T], users *Handlers[U], orders *Handlers[O], payments *Handlers[P]) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		start := time.Now()
 
-        // Parse date from URL parameter
-        dateCreated, err := time.Parse("2006-01-02", c.Param("date_created"))
-
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
-            return
-        }
-
-        var tickets []T
-        var userIDs []uint
-        var ticketIDs []uint
-
-        db := database.GetDB().Debug()
-
-        // Find tickets with the specific date_created
-        if err := db.Where("DATE(date_created) = ?", dateCreated).Find(&tickets).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        for _, ticket := range tickets {
-            userIDs = append(userIDs, ticket.GetUserID())
-            ticketIDs = append(ticketIDs, ticket.GetTicketID())
-        }
-
-        var users []U
-        var orders []O
-        var payments []P
-
-        // Find users related to the tickets
-        if err := db.Where("id IN (?)", userIDs).Find(&users).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Find orders related to the tickets
-        if err := db.Where("ticket_id IN (?)", ticketIDs).Find(&orders).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Find payments related to the tickets
-        if err := db.Where("ticket_id IN (?)", ticketIDs).Find(&payments).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Create a custom response struct
-        type Response struct {
-            Users    []U    `json:"users"`
-            Tickets  []T `json:"tickets"`
-            Orders   []O `json:"orders"`
-            Payments []P `json:"payments"`
-        }
-
-        // Populate the response struct
-        response := Response{
-            Users:    users,
-            Tickets:  tickets,
-            Orders:   orders,
-            Payments: payments,
-        }
-		// Log request details and execution time
-        logrus.Infof("Handler: GetRecordsByTicketDateCreated | Execution time: %v", time.Since(start))
+		// Parse date from URL parameter
+		dateCreated, ok := timeParam(c, "date_created", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
 
-        c.JSON(http.StatusOK, response)
-    }
-}
+		// Find tickets created on that day
+		listRelated(c, tickets, users, orders, payments,
+			repository.Condition{Column: "date_created", Op: ">=", Value: dateCreated},
+			repository.Condition{Column: "date_created", Op: "<", Value: dateCreated.AddDate(0, 0, 1)})
 
+		// Log request details and execution time
+		logrus.Infof("Handler: GetRecordsByTicketDateCreated | Execution time: %v", time.Since(start))
+	}
+}
 
-func GetRecordsByDateTimeRange[T TicketModel, U Model, O OrderModel, P PaymentModel]() gin.HandlerFunc {
-    return func(c *gin.Context) {
+// GetRecordsByDateTimeRange lists a page of the tickets created on :date, YYYY-MM-DD, between :start_time
+// and :end_time, HH:MM:SS, with their users, orders and payments
+func GetRecordsByDateTimeRange[T TicketModel, U Model, O OrderModel, P PaymentModel](tickets *Handlers[T], users *Handlers[U], orders *Handlers[O], payments *Handlers[P]) gin.HandlerFunc {
+	return func(c *gin.Context) {
 		start := time.Now()
 
+		// Parse date parameter from URL
+		date, ok := timeParam(c, "date", "2006-01-02", "YYYY-MM-DD")
+		if !ok {
+			return
+		}
 
-        // Parse date parameter from URL
-        date, err := time.Parse("2006-01-02", c.Param("date"))
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid date format. Use YYYY-MM-DD"})
-            return
-        }
-
-        // Parse start and end times from URL
-        startTime, err := time.Parse("15:04:05", c.Param("start_time"))
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid start time format. Use HH:MM:SS"})
-            return
-        }
-
-        endTime, err := time.Parse("15:04:05", c.Param("end_time"))
-        if err != nil {
-            c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid end time format. Use HH:MM:SS"})
-            return
-        }
-
-        // Combine date with start and end times
-        startDateTime := time.Date(date.Year(), date.Month(), date.Day(), startTime.Hour(), startTime.Minute(), startTime.Second(), 0, time.UTC)
-        endDateTime := time.Date(date.Year(), date.Month(), date.Day(), endTime.Hour(), endTime.Minute(), endTime.Second(), 0, time.UTC)
-
-        var tickets []T
-        var userIDs []uint
-        var ticketIDs []uint
-
-        db := database.GetDB().Debug()
-
-        // Find tickets within the specified date and time range
-        if err := db.Where("date_created BETWEEN ? AND ?", startDateTime, endDateTime).Find(&tickets).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        for _, ticket := range tickets {
-            userIDs = append(userIDs, ticket.GetUserID())
-            ticketIDs = append(ticketIDs, ticket.GetTicketID())
-        }
-
-        var users []U
-        var orders []O
-        var payments []P
-
-        // Find users related to the tickets
-        if err := db.Where("id IN (?)", userIDs).Find(&users).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Find orders related to the tickets
-        if err := db.Where("ticket_id IN (?)", ticketIDs).Find(&orders).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Find payments related to the tickets
-        if err := db.Where("ticket_id IN (?)", ticketIDs).Find(&payments).Error; err != nil {
-            c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-            return
-        }
-
-        // Create a custom response struct
-        type Response struct {
-            Users    []U `json:"users"`
-            Tickets  []T  `json:"tickets"`
-            Orders   []O  `json:"orders"`
-            Payments []P `json:"payments"`
-        }
-
-        // Populate the response struct
-        response := Response{
-            Users:    users,
-            Tickets:  tickets,
-            Orders:   orders,
-            Payments: payments,
-        }
+		// Parse start and end times from URL
+		startTime, ok := timeParam(c, "start_time", "15:04:05", "HH:MM:SS")
+		if !ok {
+			return
+		}
+		endTime, ok := timeParam(c, "end_time", "15:04:05", "HH:MM:SS")
+		if !ok {
+			return
+		}
 
-		// Log request details and execution time
-        logrus.Infof("Handler: GetRecordsByDateTimeRange | Execution time: %v", time.Since(start))
+		// Combine date with start and end times
+		startDateTime := time.Date(date.Year(), date.Month(), date.Day(), startTime.Hour(), startTime.Minute(), startTime.Second(), 0, time.UTC)
+		endDateTime := time.Date(date.Year(), date.Month(), date.Day(), endTime.Hour(), endTime.Minute(), endTime.Second(), 0, time.UTC)
 
-        c.JSON(http.StatusOK, response)
-    }
-}
+		// Find tickets within the specified date and time range
+		listRelated(c, tickets, users, orders, payments, between("date_created", startDateTime, endDateTime)...)
 
+		// Log request details and execution time
+		logrus.Infof("Handler: GetRecordsByDateTimeRange | Execution time: %v", time.Since(start))
+	}
+}
@@ -0,0 +1,172 @@
+package handlers_test
+
+import (
+	"context"
+	"encoding/csv"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/handlers"
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+
+	"github.com/gin-gonic/gin"
+)
+
+// recordServer serves the /records routes from in-memory repositories holding users 1 and 2, a ticket of each
+// on 2024-05-01 and one of user 1 on 2024-05-02, and an order and a payment on the first ticket
+func recordServer(t *testing.T) *gin.Engine {
+	t.Helper()
+	gin.SetMode(gin.TestMode)
+	ctx := context.Background()
+	userRepo := repository.NewMemory(func(user models.User, userID uint) bool { return user.ID == userID })
+	ticketRepo := repository.NewMemory(func(ticket models.Ticket, userID uint) bool { return ticket.UserID == userID })
+	orderRepo := repository.NewMemory(func(models.Order, uint) bool { return false })
+	paymentRepo := repository.NewMemory(func(models.Payment, uint) bool { return false })
+	for _, user := range []models.User{{Name: "Ada", Email: "ada@example.com"}, {Name: "Bob", Email: "bob@example.com"}} {
+		user.Password = "hash"
+		if err := userRepo.Create(ctx, &user); err != nil {
+			t.Fatal(err)
+		}
+	}
+	may1 := time.Date(2024, 5, 1, 12, 0, 0, 0, time.UTC)
+	for _, ticket := range []models.Ticket{{UserID: 1, DateCreated: may1}, {UserID: 2, DateCreated: may1.Add(time.Hour)}, {UserID: 1, DateCreated: may1.AddDate(0, 0, 1)}} {
+		if err := ticketRepo.Create(ctx, &ticket); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := orderRepo.Create(ctx, &models.Order{TicketID: 1, MenuItem: "Latte", Quantity: 2, Price: 4.5, CreatedAtTime: may1}); err != nil {
+		t.Fatal(err)
+	}
+	if err := paymentRepo.Create(ctx, &models.Payment{TicketID: 1, Amount: 9, Method: "Card", CreatedAtTime: may1}); err != nil {
+		t.Fatal(err)
+	}
+
+	users := handlers.NewHandlers[models.User](userRepo, handlers.Hooks[models.User]{})
+	tickets := handlers.NewTicketHandlers(ticketRepo)
+	orders := handlers.NewOrderHandlers(orderRepo, ticketRepo)
+	payments := handlers.NewPaymentHandlers(paymentRepo, ticketRepo)
+
+	router := gin.New()
+	router.Use(middleware.ErrorMiddleware(), func(c *gin.Context) {
+		switch user := c.GetHeader("X-User"); user {
+		case "staff":
+			auth.SetCurrentUser(c, auth.Principal{UserID: 3, Roles: []string{auth.RoleStaff}})
+		case "1", "2":
+			auth.SetCurrentUser(c, auth.Principal{UserID: uint(user[0] - '0'), Roles: []string{auth.RoleCustomer}})
+		}
+	})
+	router.GET("/records/date/:date_created", handlers.GetRecordsByTicketDateCreated(tickets, users, orders, payments))
+	router.GET("/records/:date/:start_time/:end_time", handlers.GetRecordsByDateTimeRange(tickets, users, orders, payments))
+	return router
+}
+
+// export requests path as user with an Accept header
+func export(router *gin.Engine, user, path, accept string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(http.MethodGet, path, nil)
+	req.Header.Set("X-User", user)
+	req.Header.Set("Accept", accept)
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, req)
+	return w
+}
+
+func TestRecordsPage(t *testing.T) {
+	router := recordServer(t)
+
+	var page struct {
+		Users    []models.User    `json:"users"`
+		Tickets  []models.Ticket  `json:"tickets"`
+		Orders   []models.Order   `json:"orders"`
+		Payments []models.Payment `json:"payments"`
+	}
+	w := send(t, router, "staff", http.MethodGet, "/records/date/2024-05-01", "", &page)
+	if w.Code != http.StatusOK || len(page.Users) != 2 || len(page.Tickets) != 2 || len(page.Orders) != 1 || len(page.Payments) != 1 {
+		t.Errorf("answered %d %+v", w.Code, page)
+	}
+}
+
+func TestRecordsNDJSONExport(t *testing.T) {
+	router := recordServer(t)
+
+	for _, tc := range []struct {
+		name, user, path, accept string
+		tickets                  []uint
+	}{
+		{"by date", "staff", "/records/date/2024-05-01?format=ndjson", "", []uint{1, 2}},
+		{"by Accept", "staff", "/records/date/2024-05-01", "application/x-ndjson", []uint{1, 2}},
+		{"by time range", "staff", "/records/2024-05-01/12:30:00/23:59:59?format=ndjson", "", []uint{2}},
+		{"own tickets only", "1", "/records/date/2024-05-01?format=ndjson", "", []uint{1}},
+		{"nothing found", "staff", "/records/date/2024-06-01?format=ndjson", "", nil},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			w := export(router, tc.user, tc.path, tc.accept)
+			if w.Code != http.StatusOK || w.Header().Get("Content-Type") != "application/x-ndjson" {
+				t.Fatalf("answered %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+			}
+			decoder := json.NewDecoder(w.Body)
+			var tickets []uint
+			for decoder.More() {
+				var line struct {
+					Ticket   models.Ticket    `json:"ticket"`
+					User     *models.User     `json:"user"`
+					Orders   []models.Order   `json:"orders"`
+					Payments []models.Payment `json:"payments"`
+				}
+				if err := decoder.Decode(&line); err != nil {
+					t.Fatal(err)
+				}
+				tickets = append(tickets, line.Ticket.TicketID)
+				if line.User == nil || line.User.ID != line.Ticket.UserID {
+					t.Errorf("ticket %d came with user %+v", line.Ticket.TicketID, line.User)
+				}
+				if related := line.Ticket.TicketID == 1; (len(line.Orders) == 1) != related || (len(line.Payments) == 1) != related {
+					t.Errorf("ticket %d came with orders %+v and payments %+v", line.Ticket.TicketID, line.Orders, line.Payments)
+				}
+			}
+			if len(tickets) != len(tc.tickets) || (len(tickets) > 0 && tickets[0] != tc.tickets[0]) {
+				t.Errorf("exported tickets %v, want %v", tickets, tc.tickets)
+			}
+		})
+	}
+}
+
+func TestRecordsCSVExport(t *testing.T) {
+	router := recordServer(t)
+
+	w := export(router, "staff", "/records/date/2024-05-01", "text/csv")
+	if w.Code != http.StatusOK || !strings.HasPrefix(w.Header().Get("Content-Type"), "text/csv") {
+		t.Fatalf("answered %d %q: %s", w.Code, w.Header().Get("Content-Type"), w.Body)
+	}
+	rows, err := csv.NewReader(w.Body).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	column := map[string]int{}
+	for i, name := range rows[0] {
+		column[name] = i
+	}
+	var resources []string
+	for _, row := range rows[1:] {
+		resources = append(resources, row[0]+":"+row[column["ticket_id"]])
+	}
+	want := "user::ticket:1:order:1:payment:1:user::ticket:2"
+	if got := strings.Join(resources, ":"); got != want {
+		t.Errorf("exported %s, want %s", got, want)
+	}
+	if name := rows[1][column["name"]]; name != "Ada" {
+		t.Errorf("the first user is named %q", name)
+	}
+	if menuItem := rows[3][column["menu_item"]]; menuItem != "Latte" {
+		t.Errorf("the order is for %q", menuItem)
+	}
+	if _, ok := column["password"]; ok {
+		t.Error("the password hash is exported")
+	}
+}
@@ -0,0 +1,174 @@
+package handlers
+
+import (
+	"net/http"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// TokenResponse is returned by every endpoint that issues tokens
+type TokenResponse struct {
+	Token        string `json:"token"`
+	RefreshToken string `json:"refresh_token"`
+	ExpiresIn    int    `json:"expires_in"`
+	// MFAEnrollmentRequired tells an admin without TOTP that their admin role is withheld until they enroll
+	MFAEnrollmentRequired bool `json:"mfa_enrollment_required,omitempty"`
+}
+
+// RefreshRequest carries the refresh token for /token/refresh and /logout
+type RefreshRequest struct {
+	RefreshToken string `json:"refresh_token" binding:"required"`
+}
+
+// issueTokens creates an access token signed with keys and stores a new refresh token in familyID,
+// starting a new family when it is empty
+func issueTokens(db *gorm.DB, keys *auth.KeySet, user models.User, familyID string) (TokenResponse, error) {
+	var tokens TokenResponse
+
+	accessToken, err := keys.GenerateToken(user.ID, tokenRoles(user))
+	if err != nil {
+		return tokens, err
+	}
+	refreshToken, err := auth.GenerateRefreshToken()
+	if err != nil {
+		return tokens, err
+	}
+	if familyID == "" {
+		if familyID, err = auth.GenerateRefreshToken(); err != nil {
+			return tokens, err
+		}
+	}
+
+	record := models.RefreshToken{
+		UserID:    user.ID,
+		FamilyID:  familyID,
+		TokenHash: auth.HashToken(refreshToken),
+		ExpiresAt: time.Now().Add(auth.RefreshTokenTTL),
+	}
+	if err := db.Create(&record).Error; err != nil {
+		return tokens, err
+	}
+
+	tokens.Token = accessToken
+	tokens.RefreshToken = refreshToken
+	tokens.ExpiresIn = int(auth.AccessTokenTTL.Seconds())
+	tokens.MFAEnrollmentRequired = user.Role == auth.RoleAdmin && !user.TOTPEnabled
+	return tokens, nil
+}
+
+// tokenRoles returns the roles a credential of user carries; admins only get theirs once TOTP is enabled
+func tokenRoles(user models.User) []string {
+	if user.Role == auth.RoleAdmin && !user.TOTPEnabled {
+		return []string{auth.RoleCustomer}
+	}
+	return []string{user.Role}
+}
+
+// revokeFamily revokes every live refresh token sharing familyID
+func revokeFamily(db *gorm.DB, familyID string) error {
+	return db.Model(&models.RefreshToken{}).
+		Where("family_id = ? AND revoked_at IS NULL", familyID).
+		Update("revoked_at", time.Now()).Error
+}
+
+// errInvalidRefreshToken answers refresh tokens that are unknown or whose user is gone
+var errInvalidRefreshToken = problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "invalid refresh token")
+
+// refreshTokenError reports a failed refresh token lookup, keeping database failures distinct from unknown tokens
+func refreshTokenError(err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return errInvalidRefreshToken
+	}
+	return err
+}
+
+// RefreshToken rotates a refresh token and issues a new access token signed with keys
+func RefreshToken(db *gorm.DB, keys *auth.KeySet) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		// Every early return below rolls back, including the ones after a failed statement
+		tx := db.Begin()
+		defer tx.RollbackUnlessCommitted()
+
+		var record models.RefreshToken
+		if err := tx.Set("gorm:query_option", "FOR UPDATE").Where("token_hash = ?", auth.HashToken(req.RefreshToken)).First(&record).Error; err != nil {
+			problem.Abort(c, refreshTokenError(err))
+			return
+		}
+
+		// A revoked token being presented again means it was stolen, so the whole family goes
+		if record.RevokedAt != nil {
+			err := revokeFamily(tx, record.FamilyID)
+			if err == nil {
+				err = tx.Commit().Error
+			}
+			if err != nil {
+				problem.Abort(c, problem.Internal("failed to revoke token family", err))
+				return
+			}
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "refresh token reuse detected"))
+			return
+		}
+		if time.Now().After(record.ExpiresAt) {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "refresh token expired"))
+			return
+		}
+
+		// Roles are re-read so that role changes take effect on the next refresh
+		var user models.User
+		if err := tx.First(&user, record.UserID).Error; err != nil {
+			problem.Abort(c, refreshTokenError(err))
+			return
+		}
+
+		// Rotate: revoke the presented token and issue its successor in the same family
+		if err := tx.Model(&record).Update("revoked_at", time.Now()).Error; err != nil {
+			problem.Abort(c, problem.Internal("failed to rotate token", err))
+			return
+		}
+		tokens, err := issueTokens(tx, keys, user, record.FamilyID)
+		if err == nil {
+			err = tx.Commit().Error
+		}
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to generate token", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// Logout revokes the token family of the given refresh token
+func Logout(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RefreshRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		var record models.RefreshToken
+		if err := db.Where("token_hash = ?", auth.HashToken(req.RefreshToken)).First(&record).Error; err != nil {
+			problem.Abort(c, refreshTokenError(err))
+			return
+		}
+		if err := revokeFamily(db, record.FamilyID); err != nil {
+			problem.Abort(c, problem.Internal("failed to revoke token", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"message": "logged out"})
+	}
+}
@@ -0,0 +1,219 @@
+package handlers
+
+import (
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// recoveryCodeCount is the number of recovery codes handed out on TOTP confirmation
+const recoveryCodeCount = 10
+
+// TOTPCodeRequest is the body of POST /2fa/totp/confirm
+type TOTPCodeRequest struct {
+	Code string `json:"code" binding:"required"`
+}
+
+// TOTPLoginRequest is the body of POST /login/totp; Code may be a TOTP code or a recovery code
+type TOTPLoginRequest struct {
+	ChallengeToken string `json:"challenge_token" binding:"required"`
+	Code           string `json:"code" binding:"required"`
+}
+
+// EnrollTOTP generates a new TOTP secret for the caller; it takes effect once confirmed
+func EnrollTOTP(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		principal, _ := auth.CurrentUser(c)
+
+		var user models.User
+		if err := db.First(&user, principal.UserID).Error; err != nil {
+			problem.Abort(c, lookupError[models.User](err))
+			return
+		}
+		if user.TOTPEnabled {
+			problem.Abort(c, problem.Conflict("two-factor authentication is already enabled"))
+			return
+		}
+
+		secret, err := auth.GenerateTOTPSecret()
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to generate secret", err))
+			return
+		}
+		if err := db.Model(&user).UpdateColumn("totp_secret", secret).Error; err != nil {
+			problem.Abort(c, problem.Internal("failed to store secret", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{
+			"secret":      secret,
+			"otpauth_uri": auth.TOTPURI(secret, user.Email),
+		})
+	}
+}
+
+// ConfirmTOTP enables TOTP once the caller proves their app produces valid codes, and returns recovery codes
+func ConfirmTOTP(db *gorm.DB) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TOTPCodeRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		principal, _ := auth.CurrentUser(c)
+
+		var user models.User
+		if err := db.First(&user, principal.UserID).Error; err != nil {
+			problem.Abort(c, lookupError[models.User](err))
+			return
+		}
+		if user.TOTPEnabled {
+			problem.Abort(c, problem.Conflict("two-factor authentication is already enabled"))
+			return
+		}
+		if user.TOTPSecret == "" {
+			problem.Abort(c, problem.Validation("enroll before confirming"))
+			return
+		}
+		step, ok := auth.ValidateTOTP(user.TOTPSecret, req.Code, time.Now())
+		if !ok {
+			problem.Abort(c, problem.Validation("invalid code"))
+			return
+		}
+
+		codes := make([]string, recoveryCodeCount)
+		hashes := make([]string, recoveryCodeCount)
+		for i := range codes {
+			code, err := auth.GenerateRecoveryCode()
+			if err != nil {
+				problem.Abort(c, problem.Internal("failed to generate recovery codes", err))
+				return
+			}
+			hash, err := models.HashPassword(code)
+			if err != nil {
+				problem.Abort(c, problem.Internal("failed to generate recovery codes", err))
+				return
+			}
+			codes[i], hashes[i] = code, hash
+		}
+
+		err := db.Transaction(func(tx *gorm.DB) error {
+			if err := tx.Where("user_id = ?", user.ID).Delete(&models.RecoveryCode{}).Error; err != nil {
+				return err
+			}
+			for _, hash := range hashes {
+				if err := tx.Create(&models.RecoveryCode{UserID: user.ID, CodeHash: hash}).Error; err != nil {
+					return err
+				}
+			}
+			return tx.Model(&user).UpdateColumns(map[string]interface{}{
+				"totp_enabled":   true,
+				"totp_last_step": step,
+			}).Error
+		})
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to enable two-factor authentication", err))
+			return
+		}
+
+		c.JSON(http.StatusOK, gin.H{"recovery_codes": codes})
+	}
+}
+
+// LoginTOTP exchanges a challenge token and a TOTP or recovery code for access and refresh tokens,
+// both signed with keys
+func LoginTOTP(db *gorm.DB, keys *auth.KeySet, guard *auth.LoginGuard) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req TOTPLoginRequest
+		if err := c.ShouldBindJSON(&req); err != nil {
+			problem.Abort(c, err)
+			return
+		}
+
+		userID, err := keys.ParseChallengeToken(req.ChallengeToken)
+		if err != nil {
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "invalid or expired challenge token"))
+			return
+		}
+
+		var user models.User
+		switch err := db.First(&user, userID).Error; {
+		case err != nil && !gorm.IsRecordNotFoundError(err):
+			problem.Abort(c, problem.Internal("failed to look up user", err))
+			return
+		case err != nil || !user.TOTPEnabled:
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "invalid or expired challenge token"))
+			return
+		}
+
+		// Codes are only 6 digits, so they share the password lockout
+		wait, err := guard.Allow(user.Email, c.ClientIP())
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to check login attempts", err))
+			return
+		}
+		if wait > 0 {
+			auditLoginFailure(db, user.Email, c.ClientIP(), "locked")
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			problem.Abort(c, problem.New(http.StatusTooManyRequests, problem.CodeTooManyRequests, "too many failed login attempts, try again later"))
+			return
+		}
+
+		if !verifySecondFactor(db, user, req.Code) {
+			auditLoginFailure(db, user.Email, c.ClientIP(), "bad_totp")
+			if err := guard.Failure(user.Email, c.ClientIP()); err != nil {
+				problem.Abort(c, problem.Internal("failed to record login attempt", err))
+				return
+			}
+			problem.Abort(c, problem.New(http.StatusUnauthorized, problem.CodeUnauthorized, "invalid code"))
+			return
+		}
+		if err := guard.Success(user.Email); err != nil {
+			problem.Abort(c, problem.Internal("failed to record login attempt", err))
+			return
+		}
+
+		tokens, err := issueTokens(db, keys, user, "")
+		if err != nil {
+			problem.Abort(c, problem.Internal("failed to generate token", err))
+			return
+		}
+		c.JSON(http.StatusOK, tokens)
+	}
+}
+
+// verifySecondFactor accepts a TOTP code newer than the last one used, or an unused recovery code
+func verifySecondFactor(db *gorm.DB, user models.User, code string) bool {
+	if step, ok := auth.ValidateTOTP(user.TOTPSecret, code, time.Now()); ok {
+		// The conditional update makes each code usable once even under concurrent requests
+		result := db.Model(&models.User{}).
+			Where("id = ? AND totp_last_step < ?", user.ID, step).
+			UpdateColumn("totp_last_step", step)
+		return result.Error == nil && result.RowsAffected == 1
+	}
+
+	var recoveryCodes []models.RecoveryCode
+	if err := db.Where("user_id = ? AND used_at IS NULL", user.ID).Find(&recoveryCodes).Error; err != nil {
+		return false
+	}
+	normalized := strings.ToUpper(strings.TrimSpace(code))
+	for _, recoveryCode := range recoveryCodes {
+		if models.CheckPasswordHash(recoveryCode.CodeHash, normalized) {
+			result := db.Model(&models.RecoveryCode{}).
+				Where("id = ? AND used_at IS NULL", recoveryCode.ID).
+				UpdateColumn("used_at", time.Now())
+			return result.Error == nil && result.RowsAffected == 1
+		}
+	}
+	return false
+}
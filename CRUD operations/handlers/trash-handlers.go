@@ -0,0 +1,64 @@
+package handlers
+
+import (
+	"net/http"
+	"strconv"
+
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+)
+
+// Restore brings a soft-deleted record back from the trash
+func (h *Handlers[T]) Restore() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if !authorize[T](c, ActionRestore) {
+			return
+		}
+		id, ok := trashID[T](c)
+		if !ok {
+			return
+		}
+		record, err := h.repo.Restore(c.Request.Context(), id, h.scope(c))
+		if err != nil {
+			problem.Abort(c, trashLookupError[T](writeError(err)))
+			return
+		}
+		c.Header("ETag", etag(*versionOf(&record)))
+		c.JSON(http.StatusOK, record)
+	}
+}
+
+// Purge permanently deletes a record that is already in the trash
+func (h *Handlers[T]) Purge() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id, ok := trashID[T](c)
+		if !ok {
+			return
+		}
+		if err := h.repo.Purge(c.Request.Context(), id); err != nil {
+			problem.Abort(c, trashLookupError[T](err))
+			return
+		}
+		c.JSON(http.StatusOK, gin.H{"message": resourceName[T]() + " purged"})
+	}
+}
+
+// trashID reads the :id of a record in the trash; one that is not a number is not there
+func trashID[T Model](c *gin.Context) (uint64, bool) {
+	id, err := strconv.ParseUint(c.Param("id"), 10, 64)
+	if err != nil {
+		problem.Abort(c, trashLookupError[T](gorm.ErrRecordNotFound))
+		return 0, false
+	}
+	return id, true
+}
+
+// trashLookupError reports a record missing from the trash as "<resource> not found in trash"
+func trashLookupError[T Model](err error) error {
+	if gorm.IsRecordNotFoundError(err) {
+		return problem.NotFound(resourceName[T]() + " not found in trash")
+	}
+	return err
+}
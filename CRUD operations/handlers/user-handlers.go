@@ -2,11 +2,16 @@ package handlers
 
 import (
 	"net/http"
+	"strconv"
 
-	"go-gin-postgres/database"
+	"go-gin-postgres/mailer"
 	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
 )
 
 type Model interface{
@@ -15,94 +20,76 @@ type Model interface{
 
 
 
-func GetAll[T Model]() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var records []T
-		db := database.GetDB()
-		db = db.Debug()
-		db.Find(&records)
-		c.JSON(http.StatusOK, records)
-	}
+// NewUserHandlers returns the user handlers on repo. The password of a new user, and a password that
+// an update changes, is hashed before it is stored. A changed email is mailed a verification link under baseURL,
+// with its token stored in db
+func NewUserHandlers(repo repository.Repository[models.User], db *gorm.DB, m mailer.Mailer, baseURL string) *Handlers[models.User] {
+	return NewHandlers(repo, Hooks[models.User]{
+		BeforeCreate: func(c *gin.Context, user *models.User) error {
+			return setPassword(user)
+		},
+		BeforeUpdate: func(c *gin.Context, original models.User, user *models.User) error {
+			if user.Password == original.Password {
+				return nil
+			}
+			return setPassword(user)
+		},
+		AfterUpdate: func(c *gin.Context, original models.User, user models.User) {
+			if user.Email == original.Email {
+				return
+			}
+			if err := reverifyEmail(db, m, baseURL, user); err != nil {
+				logrus.Errorf("failed to send verification email to %s: %v", user.Email, err)
+			}
+		},
+	})
 }
 
-func Create[T Model]() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var record T
-		if err := c.ShouldBindJSON(&record); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
-			return
-		}
-		db := database.GetDB()
-		db.Create(&record)
-		c.JSON(http.StatusOK, record)
+// setPassword hashes the plain-text password a request put on user, after checking its length:
+// bcrypt only reads the first 72 bytes
+func setPassword(user *models.User) error {
+	if len(user.Password) < 8 || len(user.Password) > 72 {
+		return problem.Validation("password must be between 8 and 72 bytes",
+			problem.FieldError{Field: "password", Reason: "len"})
 	}
+	return user.SetPassword(user.Password)
 }
 
-
-
-func GetByID[T Model]() gin.HandlerFunc {
+// GetUsersByRange lists the users in h with IDs from :start_id to :end_id
+func GetUsersByRange[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		var record T
-		id := c.Param("id")
-		db := database.GetDB()
-		if err := db.First(&record, id).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
-			return
+		var ids [2]uint64
+		for i, name := range []string{"start_id", "end_id"} {
+			id, err := strconv.ParseUint(c.Param(name), 10, 64)
+			if err != nil {
+				problem.Abort(c, problem.Validation(name+" must be a positive integer",
+					problem.FieldError{Field: name, Reason: "numeric"}))
+				return
+			}
+			ids[i] = id
 		}
-		c.JSON(http.StatusOK, record)
+		pk := repository.PrimaryKey[T]()
+		h.listWhere(c, repository.Condition{Column: pk, Op: ">=", Value: ids[0]}, repository.Condition{Column: pk, Op: "<=", Value: ids[1]})
 	}
 }
 
-func UpdateByID[T Model]() gin.HandlerFunc {
+// GetUserByName answers the first user in h named :name
+func GetUserByName[T Model](h *Handlers[T]) gin.HandlerFunc {
 	return func(c *gin.Context) {
-		id := c.Param("id")
-		var record T
-		db := database.GetDB()
-		if err := db.First(&record, id).Error; err != nil {
-			c.JSON(http.StatusNotFound, gin.H{"error": "user not found"})
+		if !authorize[T](c, ActionRead) {
 			return
 		}
-		if err := c.ShouldBindJSON(&record); err != nil {
-			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		scope := h.scope(c)
+		scope.Where = append(scope.Where, repository.Condition{Column: "name", Op: "=", Value: c.Param("name")})
+		records, err := h.repo.List(c.Request.Context(), repository.Query{Scope: scope, Limit: 1})
+		if err != nil {
+			problem.Abort(c, err)
 			return
 		}
-		db.Save(&record)
-		c.JSON(http.StatusOK, record)
-	}
-}
-
-func DeleteByID[T Model]() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var record T
-		id := c.Param("id")
-		db := database.GetDB()
-		if err := db.First(&record, id).Error; err != nil {
-			c.JSON(404, gin.H{"error": "user not found"})
+		if len(records) == 0 {
+			problem.Abort(c, lookupError[T](repository.ErrNotFound))
 			return
 		}
-		db.Delete(&record)
-		c.JSON(200, gin.H{"message": "user deleted"})
-	}
-}
-
-func GetUsersByRange[T Model]() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		startID := c.Param("start_id")
-		endID := c.Param("end_id")
-		var record []T
-		db := database.GetDB()
-		db.Where("id >= ? AND id <= ?", startID, endID).Find(&record)
-		c.JSON(200, record)
+		c.JSON(http.StatusOK, records[0])
 	}
 }
-
-func GetUserByName[T Model]() gin.HandlerFunc {
-	return func(c *gin.Context) {
-		var record T
-		name := c.Param("name")
-		db := database.GetDB()
-		db.Where("name = ?", name).Find(&record)
-		c.JSON(http.StatusOK, record)
-	}
-}
-
@@ -1,13 +1,27 @@
 package main
 
 import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+	"time"
+
 	"go-gin-postgres/auth"
+	"go-gin-postgres/config"
 	"go-gin-postgres/database"
 	"go-gin-postgres/handlers"
+	"go-gin-postgres/mailer"
 	"go-gin-postgres/middleware"
 	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+	"go-gin-postgres/repository"
 
 	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 )
 
@@ -17,54 +31,283 @@ func main() {
 	// Initialize the logger
 	logger := logrus.New()	
 	logger.SetFormatter(&logrus.TextFormatter{})
-	logger.SetLevel(logrus.DebugLevel) // Set log level to debug for capturing SQL queries
+
+	// "migrate" manages the schema instead of serving
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		if err := runMigrate(os.Args[2:]); err != nil && !errors.Is(err, flag.ErrHelp) {
+			logger.Fatalf("Migration failed: %v", err)
+		}
+		return
+	}
+
+	// "drift" compares the schema to the models and exits 1 on drift, 2 when it cannot tell, like diff
+	if len(os.Args) > 1 && os.Args[1] == "drift" {
+		drifted, err := runDrift(os.Args[2:])
+		if err != nil && !errors.Is(err, flag.ErrHelp) {
+			logger.Errorf("Drift check failed: %v", err)
+			os.Exit(2)
+		}
+		if drifted {
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Load the settings from the environment, the config file and the flags
+	cfg, err := config.Load(os.Args[1:])
+	if errors.Is(err, flag.ErrHelp) {
+		return
+	}
+	if err != nil {
+		logger.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Validate(); err != nil {
+		logger.Fatalf("Invalid configuration: %v", err)
+	}
+	level, _ := logrus.ParseLevel(cfg.Log.Level)
+	logger.SetLevel(level)
+	if cfg.Log.Format == "json" {
+		logger.SetFormatter(&logrus.JSONFormatter{})
+	}
 	
-	// Initialize the database
-	db, err := database.Initialize(logger)
+	// Load the JWT signing keys
+	keys, err := auth.LoadKeys(cfg.JWT)
 	if err != nil {
+		logger.Fatalf("Failed to load signing keys: %v", err)
+	}
+
+	// Outbound mail for registration and password reset
+	mail, err := mailer.New(cfg.Mail)
+	if err != nil {
+		logger.Fatalf("Failed to configure mailer: %v", err)
+	}
+	baseURL := cfg.Server.BaseURL
+
+	// Initialize the database
+	db, dbState, err := database.Initialize(cfg.Database, logger, models.Resources()...)
+	if errors.Is(err, database.ErrUnavailable) {
+		// Serve anyway: requests that need the database answer 503 until it comes up
+		logger.Warnf("Starting degraded: %v", err)
+	} else if err != nil {
 		// Handle error if database initialization fails
 		logger.Fatalf("Failed to initialize database: %v", err)
 	}
 	defer db.Close()
 
-	// Enable Gorm's debug mode to log SQL queries
-	db.LogMode(true)
+	// Track failed logins in Postgres so lockouts hold across instances
+	loginGuard := auth.NewLoginGuard(auth.NewPostgresAttemptStore(db))
 
 	router := gin.Default()
 
+	// Only believe X-Forwarded-For from the configured proxies, so that clients cannot pick the IP
+	// that failed logins are counted against
+	if err := router.SetTrustedProxies(cfg.Server.TrustedProxies); err != nil {
+		logger.Fatalf("Invalid trusted proxies: %v", err)
+	}
+
 	// Use logging middleware
 	router.Use(middleware.LoggingMiddleware())
 
-	router.POST("/login", handlers.Login())
+	// Render handler errors as application/problem+json
+	router.Use(middleware.ErrorMiddleware())
+	router.NoRoute(func(c *gin.Context) {
+		problem.Abort(c, problem.NotFound("no route for "+c.Request.Method+" "+c.Request.URL.Path))
+	})
 
-	// Group routes that require authentication
-	authorized := router.Group("/")
-	authorized.Use(auth.Authenticate)
+	router.GET("/health", handlers.Health(dbState))
+	router.GET("/.well-known/jwks.json", handlers.JWKS(keys))
 
-	// User routes
-	authorized.GET("/users", handlers.GetAll[models.User]())
-	authorized.POST("/users", handlers.Create[models.User]())
-	authorized.GET("/users/:id", handlers.GetByID[models.User]())
-	authorized.PUT("/users/:id", handlers.UpdateByID[models.User]())
-	authorized.DELETE("/users/:id", handlers.DeleteByID[models.User]())
-	authorized.GET("/users/range/:start_id/:end_id", handlers.GetUsersByRange[models.User]())
-	authorized.GET("/users/byname/:name", handlers.GetUserByName[models.User]())
+	// Routes that use the database answer 503 until it is reachable and its schema matches
+	api := router.Group("/", middleware.DatabaseReady(dbState.Err))
+	api.POST("/login", handlers.Login(db, keys, loginGuard))
+	api.POST("/login/totp", handlers.LoginTOTP(db, keys, loginGuard))
+	api.POST("/token/refresh", handlers.RefreshToken(db, keys))
+	api.POST("/logout", handlers.Logout(db))
+	api.POST("/register", handlers.Register(db, mail, baseURL))
+	api.POST("/verify-email", handlers.VerifyEmail(db))
+	api.POST("/password/forgot", handlers.ForgotPassword(db, mail, baseURL))
+	api.POST("/password/reset", handlers.ResetPassword(db))
 
-	// Ticket routes
-	authorized.GET("/tickets/date/:start_date/:end_date", handlers.GetTicketsByDate[models.Ticket]())
-	authorized.GET("/tickets/date/time/:start_date/:end_date", handlers.GetTicketsByDateTime[models.Ticket]())
-	authorized.GET("/tickets/:user_id", handlers.GetTicketsByUserId[models.Ticket]())
-	authorized.GET("/tickets/payment/:status", handlers.GetTicketsByPaymentStatus[models.Ticket]())
-	authorized.GET("/records/date/:date_created", handlers.GetRecordsByTicketDateCreated[models.Ticket, models.User, models.Order, models.Payment]())
-	authorized.GET("/records/:date/:start_time/:end_time", handlers.GetRecordsByDateTimeRange[models.Ticket, models.User, models.Order, models.Payment]())
+	// Group routes that require authentication, by token or by an API key looked up in the database
+	authorized := api.Group("/")
+	authorized.Use(auth.Authenticate(keys, handlers.ResolveAPIKey(db)))
+
+	// API key routes
+	authorized.POST("/api-keys", handlers.CreateAPIKey(db))
+	authorized.GET("/api-keys", handlers.ListAPIKeys(db))
+	authorized.DELETE("/api-keys/:id", handlers.RevokeAPIKey(db))
 
+	// Two-factor authentication routes
+	authorized.POST("/2fa/totp/enroll", handlers.EnrollTOTP(db))
+	authorized.POST("/2fa/totp/confirm", handlers.ConfirmTOTP(db))
 
+	// Data routes accept an Idempotency-Key on POST. The credential routes above are left out,
+	// so that API keys and TOTP secrets are never stored with a response
+	resources := authorized.Group("/", middleware.Idempotency(db, 24*time.Hour))
+	go middleware.SweepIdempotencyKeys(context.Background(), db, time.Hour)
+
+	// Each resource is served from a repository on the database
+	userHandlers := handlers.NewUserHandlers(repository.NewGorm[models.User](db), db, mail, baseURL)
+	ticketRepo := repository.NewGorm[models.Ticket](db)
+	ticketHandlers := handlers.NewTicketHandlers(ticketRepo)
+	orderHandlers := handlers.NewOrderHandlers(repository.NewGorm[models.Order](db), ticketRepo)
+	paymentHandlers := handlers.NewPaymentHandlers(repository.NewGorm[models.Payment](db), ticketRepo)
+
+	// User routes
+	users := handlers.RegisterResource(resources, "/users", userHandlers)
+	users.POST("/:id/unlock", auth.Require(auth.RoleAdmin), handlers.UnlockUser(userHandlers, loginGuard))
+	users.GET("/range/:start_id/:end_id", auth.Require(auth.RoleAdmin, auth.RoleStaff), handlers.GetUsersByRange(userHandlers))
+	users.GET("/byname/:name", auth.Require(auth.RoleAdmin, auth.RoleStaff), handlers.GetUserByName(userHandlers))
+	handlers.RegisterNested(users, "/tickets", "user_id", userHandlers, ticketHandlers)
+
+	// Ticket routes
+	tickets := handlers.RegisterResource(resources, "/tickets", ticketHandlers)
+	tickets.GET("/date/:start_date/:end_date", handlers.GetTicketsByDate(ticketHandlers))
+	tickets.GET("/date/time/:start_date/:end_date", handlers.GetTicketsByDateTime(ticketHandlers))
+	tickets.GET("/user/:user_id", handlers.GetTicketsByUserId(ticketHandlers))
+	tickets.GET("/payment/:status", handlers.GetTicketsByPaymentStatus(ticketHandlers))
+	handlers.RegisterNested(tickets, "/orders", "ticket_id", ticketHandlers, orderHandlers)
+	handlers.RegisterNested(tickets, "/payments", "ticket_id", ticketHandlers, paymentHandlers)
+	resources.GET("/records/date/:date_created", handlers.GetRecordsByTicketDateCreated(ticketHandlers, userHandlers, orderHandlers, paymentHandlers))
+	resources.GET("/records/:date/:start_time/:end_time", handlers.GetRecordsByDateTimeRange(ticketHandlers, userHandlers, orderHandlers, paymentHandlers))
 
 	// Order routes
-	authorized.GET("/orders/date/:start_date/:end_date", handlers.GetOrdersByDate[models.Order]())
+	orders := handlers.RegisterResource(resources, "/orders", orderHandlers)
+	orders.GET("/date/:start_date/:end_date", handlers.GetOrdersByDate(orderHandlers))
 
 	// Payment routes
-	authorized.GET("/payments/date/:start_date/:end_date", handlers.GetPaymentsByDate[models.Payment]())
+	payments := handlers.RegisterResource(resources, "/payments", paymentHandlers)
+	payments.GET("/date/:start_date/:end_date", handlers.GetPaymentsByDate(paymentHandlers))
+
+	router.Run(cfg.Server.Addr)
+}
+
+// migrateUsage describes the migrate subcommand
+const migrateUsage = "usage: migrate up|down|status|to <version>|baseline <version> [flags]"
 
-	router.Run(":8080")
+// runMigrate runs "migrate up", "down", "status", "to <version>" or "baseline <version>" on the database of
+// the config flags that follow, then prints the status of every migration
+func runMigrate(args []string) error {
+	if len(args) == 0 {
+		return errors.New(migrateUsage)
+	}
+	command, args := args[0], args[1:]
+	var version uint64
+	if command == "to" || command == "baseline" {
+		if len(args) == 0 {
+			return errors.New(migrateUsage)
+		}
+		var err error
+		if version, err = strconv.ParseUint(args[0], 10, 32); err != nil {
+			return fmt.Errorf("invalid version %q", args[0])
+		}
+		args = args[1:]
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return err
+	}
+	pool, err := database.Open(cfg.Database)
+	if err != nil {
+		return err
+	}
+	defer pool.Close()
+	migrator, err := database.NewMigrator(pool)
+	if err != nil {
+		return err
+	}
+	migrator.TimeZone = cfg.Database.LegacyTimeZone
+
+	ctx := context.Background()
+	switch command {
+	case "up":
+		err = migrator.Up(ctx)
+	case "down":
+		err = migrator.Down(ctx)
+	case "to":
+		err = migrator.To(ctx, uint(version))
+	case "baseline":
+		err = migrator.Baseline(ctx, uint(version))
+	case "status":
+	default:
+		return errors.New(migrateUsage)
+	}
+	if err != nil {
+		return err
+	}
+
+	status, err := migrator.Status(ctx)
+	if err != nil {
+		return err
+	}
+	current, dirty, err := migrator.Version(ctx)
+	if err != nil {
+		return err
+	}
+	for _, migration := range status {
+		state := "pending"
+		if migration.Applied {
+			state = "applied"
+		}
+		fmt.Printf("%-8s %06d_%s\n", state, migration.Version, migration.Name)
+	}
+	fmt.Printf("schema version %d of %d", current, migrator.Latest())
+	if dirty {
+		fmt.Print(" (dirty)")
+	}
+	fmt.Println()
+	return nil
+}
+
+// runDrift runs "drift [text|json]" on the database of the config flags that follow: it prints how the
+// schema differs from the models and reports whether it does
+func runDrift(args []string) (bool, error) {
+	format := "text"
+	if len(args) > 0 && (args[0] == "text" || args[0] == "json") {
+		format, args = args[0], args[1:]
+	}
+
+	cfg, err := config.Load(args)
+	if err != nil {
+		return false, err
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		return false, err
+	}
+	pool, err := database.Open(cfg.Database)
+	if err != nil {
+		return false, err
+	}
+	defer pool.Close()
+	db, err := gorm.Open("postgres", pool)
+	if err != nil {
+		return false, err
+	}
+
+	differences, err := database.Drift(db, models.Resources()...)
+	if err != nil {
+		return false, err
+	}
+	if format == "json" {
+		if differences == nil {
+			differences = []database.Difference{}
+		}
+		encoder := json.NewEncoder(os.Stdout)
+		encoder.SetIndent("", "  ")
+		err = encoder.Encode(map[string]interface{}{"drift": len(differences) > 0, "differences": differences})
+		return len(differences) > 0, err
+	}
+	for _, difference := range differences {
+		fmt.Println(difference)
+	}
+	if len(differences) == 0 {
+		fmt.Println("no drift: the schema matches the models")
+	} else {
+		fmt.Printf("%d differences\n", len(differences))
+	}
+	return len(differences) > 0, nil
 }
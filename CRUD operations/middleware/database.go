@@ -0,0 +1,21 @@
+// middleware/database.go
+
+package middleware
+
+import (
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+// DatabaseReady answers 503 database_unavailable while check reports why the database cannot be used,
+// such as a schema that does not match this build, instead of letting the request run against it
+func DatabaseReady(check func() error) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if err := check(); err != nil {
+			problem.Abort(c, problem.Unavailable(err))
+			return
+		}
+		c.Next()
+	}
+}
@@ -0,0 +1,52 @@
+package middleware_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestDatabaseReady(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	for _, tc := range []struct {
+		name   string
+		err    error
+		status int
+	}{
+		{"ready", nil, http.StatusOK},
+		{"not ready", errors.New("database schema does not match this build"), http.StatusServiceUnavailable},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			reached := false
+			router := gin.New()
+			router.Use(middleware.ErrorMiddleware())
+			router.GET("/", middleware.DatabaseReady(func() error { return tc.err }), func(c *gin.Context) {
+				reached = true
+				c.Status(http.StatusOK)
+			})
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if w.Code != tc.status || reached != (tc.err == nil) {
+				t.Fatalf("answered %d, handler reached: %v", w.Code, reached)
+			}
+			if tc.err == nil {
+				return
+			}
+			var p problem.Problem
+			if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil {
+				t.Fatalf("%v in %s", err, w.Body)
+			}
+			if p.Code != problem.CodeDatabaseUnavailable {
+				t.Errorf("answered code %q, want %q", p.Code, problem.CodeDatabaseUnavailable)
+			}
+		})
+	}
+}
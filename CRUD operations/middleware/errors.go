@@ -0,0 +1,50 @@
+// middleware/errors.go
+
+package middleware
+
+import (
+	"reflect"
+	"strings"
+
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+	"github.com/go-playground/validator/v10"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrorMiddleware renders the last error a handler recorded with c.Error as application/problem+json
+func ErrorMiddleware() gin.HandlerFunc {
+	// Report validation errors by JSON name rather than Go field name
+	if v, ok := binding.Validator.Engine().(*validator.Validate); ok {
+		v.RegisterTagNameFunc(func(field reflect.StructField) string {
+			name := strings.Split(field.Tag.Get("json"), ",")[0]
+			if name == "" || name == "-" {
+				return field.Name
+			}
+			return name
+		})
+	}
+
+	return func(c *gin.Context) {
+		c.Next()
+
+		if len(c.Errors) == 0 {
+			return
+		}
+		p := problem.From(c.Errors.Last().Err)
+		if p.Status >= 500 {
+			logrus.Errorf("%s %s: %v", c.Request.Method, c.Request.URL.Path, p)
+		}
+		writeError(c)
+	}
+}
+
+// writeError renders the last recorded error as the response, unless a response was already written
+func writeError(c *gin.Context) {
+	if len(c.Errors) == 0 || c.Writer.Written() {
+		return
+	}
+	problem.Write(c, problem.From(c.Errors.Last().Err))
+}
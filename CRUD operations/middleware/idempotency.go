@@ -0,0 +1,203 @@
+// middleware/idempotency.go
+
+package middleware
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/models"
+	"go-gin-postgres/problem"
+
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	"github.com/sirupsen/logrus"
+)
+
+// IdempotencyHeader names the header that makes a POST safe to retry
+const IdempotencyHeader = "Idempotency-Key"
+
+// maxIdempotencyKeyLength is the longest key accepted, the width of the key column
+const maxIdempotencyKeyLength = 255
+
+// idempotencyLockTimeout is how long a request may hold its key; after that a retry takes the key over,
+// since the first request most likely died
+const idempotencyLockTimeout = time.Minute
+
+// replayedHeaders are the response headers stored with a response and sent again on replay
+var replayedHeaders = []string{"Content-Type", "ETag", "Location", "Link"}
+
+// Idempotency answers a POST that repeats the Idempotency-Key of an earlier one from the caller with the
+// earlier response for ttl, instead of running it again. Reusing a key for a different request answers 422,
+// and a retry while the first request is still running answers 409.
+// Server errors are not stored, so such requests can be retried with the same key
+func Idempotency(db *gorm.DB, ttl time.Duration) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		key := c.GetHeader(IdempotencyHeader)
+		if c.Request.Method != http.MethodPost || key == "" {
+			c.Next()
+			return
+		}
+		if len(key) > maxIdempotencyKeyLength {
+			problem.Abort(c, problem.Validation("the Idempotency-Key header is too long",
+				problem.FieldError{Field: IdempotencyHeader, Reason: "max"}))
+			return
+		}
+		body, err := io.ReadAll(c.Request.Body)
+		if err != nil {
+			problem.Abort(c, problem.BadRequest("the request body could not be read"))
+			return
+		}
+		c.Request.Body = io.NopCloser(bytes.NewReader(body))
+
+		principal, _ := auth.CurrentUser(c)
+		fingerprint := fingerprintRequest(c.Request, body)
+		record, reserved, err := reserveKey(db, principal.UserID, key, fingerprint, ttl)
+		if err != nil {
+			problem.Abort(c, err)
+			return
+		}
+		if !reserved {
+			switch {
+			case record.Fingerprint != fingerprint:
+				problem.Abort(c, problem.New(http.StatusUnprocessableEntity, problem.CodeIdempotencyKeyReused,
+					"this Idempotency-Key was already used for a different request"))
+			case record.Status == 0:
+				problem.Abort(c, problem.Conflict("a request with this Idempotency-Key is still being processed"))
+			default:
+				replay(c, record)
+			}
+			return
+		}
+
+		recorder := &recordingWriter{ResponseWriter: c.Writer}
+		c.Writer = recorder
+		c.Next()
+		// Errors are rendered here rather than by ErrorMiddleware so that the problem body is stored
+		writeError(c)
+
+		if status := c.Writer.Status(); status >= http.StatusInternalServerError {
+			err = db.Delete(&record).Error
+		} else {
+			err = storeResponse(db, record, status, c.Writer.Header(), recorder.body.Bytes())
+		}
+		if err != nil {
+			logrus.Errorf("idempotency key %q of user %d: %v", key, principal.UserID, err)
+		}
+	}
+}
+
+// SweepIdempotencyKeys deletes the expired keys of every user now and then every interval until ctx is done.
+// reserveKey only drops the expired keys of the caller, so without the sweep the keys of users who never send
+// another one would be kept forever
+func SweepIdempotencyKeys(ctx context.Context, db *gorm.DB, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		result := db.Where("expires_at < ?", time.Now()).Delete(&models.IdempotencyKey{})
+		if result.Error != nil {
+			logrus.Errorf("failed to sweep expired idempotency keys: %v", result.Error)
+		} else if result.RowsAffected > 0 {
+			logrus.Infof("swept %d expired idempotency keys", result.RowsAffected)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// fingerprintRequest hashes what makes two requests the same: method, path with query, and body
+func fingerprintRequest(r *http.Request, body []byte) string {
+	hash := sha256.New()
+	hash.Write([]byte(r.Method + " " + r.URL.RequestURI() + "\n"))
+	hash.Write(body)
+	return hex.EncodeToString(hash.Sum(nil))
+}
+
+// reserveKey claims key for a new request, or returns the record already holding it
+func reserveKey(db *gorm.DB, userID uint, key, fingerprint string, ttl time.Duration) (models.IdempotencyKey, bool, error) {
+	now := time.Now()
+	// Drop the user's expired keys, and this key if the request holding it died
+	err := db.Where("user_id = ? AND (expires_at < ? OR (key = ? AND status = 0 AND created_at < ?))",
+		userID, now, key, now.Add(-idempotencyLockTimeout)).Delete(&models.IdempotencyKey{}).Error
+	if err != nil {
+		return models.IdempotencyKey{}, false, err
+	}
+
+	record := models.IdempotencyKey{UserID: userID, Key: key, Fingerprint: fingerprint, CreatedAt: now, ExpiresAt: now.Add(ttl)}
+	row := db.Raw(`INSERT INTO idempotency_keys (user_id, key, fingerprint, status, created_at, expires_at) VALUES (?, ?, ?, 0, ?, ?)
+		ON CONFLICT (user_id, key) DO NOTHING
+		RETURNING id`, userID, key, fingerprint, record.CreatedAt, record.ExpiresAt).Row()
+	err = row.Scan(&record.ID)
+	if err == nil {
+		return record, true, nil
+	}
+	if err != sql.ErrNoRows {
+		return record, false, err
+	}
+	var existing models.IdempotencyKey
+	err = db.Where("user_id = ? AND key = ?", userID, key).First(&existing).Error
+	return existing, false, err
+}
+
+// storeResponse saves the response of a reserved key so that retries can replay it
+func storeResponse(db *gorm.DB, record models.IdempotencyKey, status int, header http.Header, body []byte) error {
+	kept := map[string]string{}
+	for _, name := range replayedHeaders {
+		if value := header.Get(name); value != "" {
+			kept[name] = value
+		}
+	}
+	encoded, err := json.Marshal(kept)
+	if err != nil {
+		return err
+	}
+	return db.Model(&record).UpdateColumns(map[string]interface{}{
+		"status": status,
+		"header": string(encoded),
+		"body":   body,
+	}).Error
+}
+
+// replay answers with a stored response
+func replay(c *gin.Context, record models.IdempotencyKey) {
+	var header map[string]string
+	if err := json.Unmarshal([]byte(record.Header), &header); err != nil {
+		problem.Abort(c, problem.Internal("the stored response could not be read", err))
+		return
+	}
+	for name, value := range header {
+		c.Header(name, value)
+	}
+	c.Header("Idempotent-Replayed", "true")
+	c.Status(record.Status)
+	_, _ = c.Writer.Write(record.Body)
+	c.Abort()
+}
+
+// recordingWriter keeps a copy of the response body
+type recordingWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+func (w *recordingWriter) Write(data []byte) (int, error) {
+	w.body.Write(data)
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *recordingWriter) WriteString(s string) (int, error) {
+	w.body.WriteString(s)
+	return w.ResponseWriter.WriteString(s)
+}
@@ -0,0 +1,151 @@
+package middleware_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"go-gin-postgres/auth"
+	"go-gin-postgres/middleware"
+	"go-gin-postgres/problem"
+
+	"github.com/DATA-DOG/go-sqlmock"
+	"github.com/gin-gonic/gin"
+	"github.com/jinzhu/gorm"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+)
+
+// mockDB returns gorm on a mocked database
+func mockDB(t *testing.T) (*gorm.DB, sqlmock.Sqlmock) {
+	t.Helper()
+	pool, mock, err := sqlmock.New()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pool.Close() })
+	db, err := gorm.Open("postgres", pool)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db, mock
+}
+
+// storedKey is a key already held in the database when a request arrives
+type storedKey struct {
+	fingerprint string
+	status      int
+	header      string
+	body        string
+}
+
+func TestIdempotency(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	const body = `{"ticket_id": 7, "menu_item": "Latte", "quantity": 1, "price": 4.5}`
+	hash := sha256.Sum256([]byte("POST /orders\n" + body))
+	fingerprint := hex.EncodeToString(hash[:])
+
+	for _, tc := range []struct {
+		name string
+		// stored is the key held by an earlier request, nil when the request reserves it
+		stored *storedKey
+		// handlerStatus is what the handler answers if it runs
+		handlerStatus int
+		status        int
+		code          string
+		reached       bool
+		replayed      bool
+	}{
+		{name: "first request", handlerStatus: http.StatusCreated, status: http.StatusCreated, reached: true},
+		{name: "server error", handlerStatus: http.StatusInternalServerError, status: http.StatusInternalServerError, reached: true},
+		{name: "replay", stored: &storedKey{fingerprint, http.StatusCreated, `{"Content-Type":"application/json"}`, `{"order_id":1}`},
+			status: http.StatusCreated, replayed: true},
+		{name: "different request", stored: &storedKey{"another fingerprint", http.StatusCreated, "{}", "{}"},
+			status: http.StatusUnprocessableEntity, code: problem.CodeIdempotencyKeyReused},
+		{name: "still running", stored: &storedKey{fingerprint: fingerprint},
+			status: http.StatusConflict, code: problem.CodeConflict},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			db, mock := mockDB(t)
+			mock.ExpectBegin()
+			mock.ExpectExec(`DELETE FROM "idempotency_keys"`).WithArgs(2, sqlmock.AnyArg(), "terminal-12-0042", sqlmock.AnyArg()).
+				WillReturnResult(sqlmock.NewResult(0, 0))
+			mock.ExpectCommit()
+			insert := mock.ExpectQuery(`INSERT INTO idempotency_keys`).
+				WithArgs(2, "terminal-12-0042", fingerprint, sqlmock.AnyArg(), sqlmock.AnyArg())
+			switch {
+			case tc.stored != nil:
+				insert.WillReturnRows(sqlmock.NewRows([]string{"id"}))
+				mock.ExpectQuery(`SELECT \* FROM "idempotency_keys"`).WillReturnRows(
+					sqlmock.NewRows([]string{"id", "user_id", "key", "fingerprint", "status", "header", "body"}).
+						AddRow(5, 2, "terminal-12-0042", tc.stored.fingerprint, tc.stored.status, tc.stored.header, []byte(tc.stored.body)))
+			case tc.handlerStatus >= http.StatusInternalServerError:
+				insert.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				// The key is released so that the request can be retried
+				mock.ExpectBegin()
+				mock.ExpectExec(`DELETE FROM "idempotency_keys"`).WithArgs(5).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			default:
+				insert.WillReturnRows(sqlmock.NewRows([]string{"id"}).AddRow(5))
+				mock.ExpectBegin()
+				mock.ExpectExec(`UPDATE "idempotency_keys" SET`).WillReturnResult(sqlmock.NewResult(0, 1))
+				mock.ExpectCommit()
+			}
+
+			reached := false
+			router := gin.New()
+			router.Use(middleware.ErrorMiddleware(), func(c *gin.Context) {
+				auth.SetCurrentUser(c, auth.Principal{UserID: 2})
+			})
+			router.POST("/orders", middleware.Idempotency(db, time.Hour), func(c *gin.Context) {
+				reached = true
+				c.JSON(tc.handlerStatus, gin.H{"order_id": 1})
+			})
+			r := httptest.NewRequest(http.MethodPost, "/orders", strings.NewReader(body))
+			r.Header.Set(middleware.IdempotencyHeader, "terminal-12-0042")
+			w := httptest.NewRecorder()
+			router.ServeHTTP(w, r)
+
+			if w.Code != tc.status || reached != tc.reached {
+				t.Fatalf("answered %d, handler reached: %v: %s", w.Code, reached, w.Body)
+			}
+			if replayed := w.Header().Get("Idempotent-Replayed") == "true"; replayed != tc.replayed {
+				t.Errorf("replayed: %v, want %v", replayed, tc.replayed)
+			}
+			if tc.replayed && (w.Body.String() != tc.stored.body || w.Header().Get("Content-Type") != "application/json") {
+				t.Errorf("replayed %q %s, want %q", w.Header().Get("Content-Type"), w.Body, tc.stored.body)
+			}
+			if tc.code != "" {
+				var p problem.Problem
+				if err := json.Unmarshal(w.Body.Bytes(), &p); err != nil || p.Code != tc.code {
+					t.Errorf("answered %s, want code %q", w.Body, tc.code)
+				}
+			}
+			if err := mock.ExpectationsWereMet(); err != nil {
+				t.Error(err)
+			}
+		})
+	}
+}
+
+func TestSweepIdempotencyKeys(t *testing.T) {
+	db, mock := mockDB(t)
+	mock.ExpectBegin()
+	mock.ExpectExec(`DELETE FROM "idempotency_keys" WHERE \(expires_at < \$1\)`).WithArgs(sqlmock.AnyArg()).
+		WillReturnResult(sqlmock.NewResult(0, 3))
+	mock.ExpectCommit()
+
+	// A done context stops the sweep after the first round
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	middleware.SweepIdempotencyKeys(ctx, db, time.Hour)
+
+	if err := mock.ExpectationsWereMet(); err != nil {
+		t.Error(err)
+	}
+}
@@ -1,16 +1,83 @@
 package models
 
 import (
+	"encoding/json"
+	"errors"
 	"time"
+
+	"github.com/lib/pq"
+	"golang.org/x/crypto/bcrypt"
 )
 
 // User represents a user in the system
 type User struct {
 	ID       uint      `json:"id" gorm:"primary_key"`
-	Name     string    `json:"name"  binding:"required" gorm:"not null"`
+	Name     string    `json:"name"  binding:"required" gorm:"size:255;not null"`
 	Dob      time.Time `json:"dob" gorm:"type:date;not null"`
-	Email    string    `json:"email" gorm:"unique;not null"`
-	Password string    `json:"password" gorm:"not null"`
+	Email    string    `json:"email" gorm:"size:255;unique;not null"`
+	Password string    `json:"password,omitempty" gorm:"size:255;not null" query:"-"`
+	Role     string    `json:"role" gorm:"size:255;not null;default:'customer'"`
+	// TOTPSecret is set on enrollment and only used for logins once TOTPEnabled is confirmed
+	TOTPSecret   string `json:"-" gorm:"size:255"`
+	TOTPEnabled  bool   `json:"totp_enabled" gorm:"not null;default:false"`
+	TOTPLastStep int64  `json:"-" gorm:"not null;default:0"`
+	// EmailVerifiedAt is set once the user follows the link sent on registration
+	EmailVerifiedAt *time.Time `json:"email_verified_at"`
+	// Version is bumped on every update and served as the ETag
+	Version uint `json:"version" gorm:"not null;default:1"`
+	// DeletedAt marks a soft-deleted user; gorm leaves such rows out of every query unless Unscoped
+	DeletedAt *time.Time `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// MarshalJSON serializes the user without its password hash
+func (u User) MarshalJSON() ([]byte, error) {
+	type user User
+	safe := user(u)
+	safe.Password = ""
+	return json.Marshal(safe)
+}
+
+// BeforeSave checks a user before it is written to the database. It never hashes: Password holds a hash
+// by then, set by SetPassword wherever a new password comes in
+func (u *User) BeforeSave() error {
+	if u.Password == "" {
+		return errors.New("password is required")
+	}
+	// TOTP can only be switched on through enrollment, which sets the secret first
+	if u.TOTPSecret == "" {
+		u.TOTPEnabled = false
+	}
+	return nil
+}
+
+// SetPassword stores the bcrypt hash of a plain-text password. Whatever the caller sends is taken
+// as plain text, so that a hash can never be planted as a password
+func (u *User) SetPassword(password string) error {
+	hash, err := HashPassword(password)
+	if err != nil {
+		return err
+	}
+	u.Password = hash
+	return nil
+}
+
+// CheckPassword reports whether password matches the stored hash
+func (u User) CheckPassword(password string) bool {
+	return CheckPasswordHash(u.Password, password)
+}
+
+// CheckPasswordHash reports whether password matches the bcrypt hash
+func CheckPasswordHash(hash, password string) bool {
+	return bcrypt.CompareHashAndPassword([]byte(hash), []byte(password)) == nil
+}
+
+// HashPassword returns the bcrypt hash of password
+func HashPassword(password string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(password), bcrypt.DefaultCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
 }
 
 // Ticket represents a ticket in the system
@@ -19,6 +86,8 @@ type Ticket struct {
 	UserID      uint      `json:"user_id" gorm:"foreignkey:UserID;not null"`
 	DateCreated time.Time `json:"date_created" gorm:"not null"`
 	DatePaid    *time.Time `json:"date_paid"`
+	Version     uint      `json:"version" gorm:"not null;default:1"`
+	DeletedAt   *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 
@@ -28,9 +97,11 @@ type Order struct {
 	OrderID       uint      `json:"order_id" gorm:"primary_key"`
 	TicketID      uint      `json:"ticket_id" gorm:"foreignkey:TicketID;not null"`
 	CreatedAtTime time.Time `json:"created_at_time" gorm:"not null"`
-	MenuItem      string    `json:"menu_item" gorm:"not null"`
+	MenuItem      string    `json:"menu_item" gorm:"size:255;not null"`
 	Quantity      int       `json:"quantity" gorm:"not null"`
-	Price         float64   `json:"price" gorm:"not null"`
+	Price         float64   `json:"price" gorm:"type:numeric(10,2);not null"`
+	Version       uint      `json:"version" gorm:"not null;default:1"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" gorm:"index"`
 }
 
 // Payment represents a payment in the system
@@ -38,8 +109,96 @@ type Payment struct {
 	PaymentID     uint      `json:"payment_id" gorm:"primary_key;not null"`
 	TicketID      uint      `json:"ticket_id" gorm:"foreignkey:TicketID;not null"`
 	CreatedAtTime time.Time `json:"created_at" gorm:"not null"`
-	Amount        float64   `json:"amount" gorm:"not null"`
-	Method        string    `json:"method" gorm:"not null"`
+	Amount        float64   `json:"amount" gorm:"type:numeric(10,2);not null"`
+	Method        string    `json:"method" gorm:"size:255;not null"`
+	Version       uint      `json:"version" gorm:"not null;default:1"`
+	DeletedAt     *time.Time `json:"deleted_at,omitempty" gorm:"index"`
+}
+
+// Resources returns the models of the data tables, which the drift check compares to the database
+func Resources() []interface{} {
+	return []interface{}{&User{}, &Ticket{}, &Order{}, &Payment{}}
+}
+
+// RefreshToken is a stored refresh token; tokens rotated from one login share a FamilyID
+type RefreshToken struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	FamilyID  string     `json:"family_id" gorm:"not null;index"`
+	TokenHash string     `json:"-" gorm:"unique;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	RevokedAt *time.Time `json:"revoked_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// APIKey is a long-lived credential for terminals and batch jobs; only its hash is stored
+type APIKey struct {
+	ID         uint           `json:"id" gorm:"primary_key"`
+	UserID     uint           `json:"user_id" gorm:"not null;index"`
+	Name       string         `json:"name" gorm:"not null"`
+	Prefix     string         `json:"prefix" gorm:"not null"`
+	KeyHash    string         `json:"-" gorm:"unique;not null"`
+	Scopes     pq.StringArray `json:"scopes" gorm:"type:text[];not null"`
+	ExpiresAt  *time.Time     `json:"expires_at"`
+	LastUsedAt *time.Time     `json:"last_used_at"`
+	RevokedAt  *time.Time     `json:"revoked_at"`
+	CreatedAt  time.Time      `json:"created_at"`
+}
+
+// RecoveryCode is a hashed single-use code that stands in for a TOTP code
+type RecoveryCode struct {
+	ID       uint       `json:"id" gorm:"primary_key"`
+	UserID   uint       `json:"user_id" gorm:"not null;index"`
+	CodeHash string     `json:"-" gorm:"not null"`
+	UsedAt   *time.Time `json:"used_at"`
+}
+
+// User token purposes
+const (
+	TokenVerifyEmail   = "verify_email"
+	TokenResetPassword = "reset_password"
+)
+
+// UserToken is a hashed single-use token mailed to a user for email verification or password reset
+type UserToken struct {
+	ID        uint       `json:"id" gorm:"primary_key"`
+	UserID    uint       `json:"user_id" gorm:"not null;index"`
+	Purpose   string     `json:"purpose" gorm:"not null"`
+	TokenHash string     `json:"-" gorm:"unique;not null"`
+	ExpiresAt time.Time  `json:"expires_at" gorm:"not null"`
+	UsedAt    *time.Time `json:"used_at"`
+	CreatedAt time.Time  `json:"created_at"`
+}
+
+// LoginAttempt is the failed-login counter of one account or client IP
+type LoginAttempt struct {
+	Subject       string    `json:"subject" gorm:"primary_key"`
+	Failures      int       `json:"failures" gorm:"not null"`
+	LastFailureAt time.Time `json:"last_failure_at" gorm:"not null"`
+}
+
+// LoginFailure is the audit record of one failed login
+type LoginFailure struct {
+	ID        uint      `json:"id" gorm:"primary_key"`
+	Email     string    `json:"email" gorm:"not null;index"`
+	IP        string    `json:"ip" gorm:"not null"`
+	Reason    string    `json:"reason" gorm:"not null"`
+	CreatedAt time.Time `json:"created_at" gorm:"index"`
+}
+
+// IdempotencyKey remembers the response to a POST sent with an Idempotency-Key header, so that a retry
+// of the same request is answered from it instead of being run again
+type IdempotencyKey struct {
+	ID          uint   `json:"id" gorm:"primary_key"`
+	UserID      uint   `json:"user_id" gorm:"not null;unique_index:idx_idempotency_keys_user_id_key"`
+	Key         string `json:"key" gorm:"not null;unique_index:idx_idempotency_keys_user_id_key"`
+	Fingerprint string `json:"fingerprint" gorm:"not null"`
+	// Status is 0 while the first request with the key is still running
+	Status    int       `json:"status" gorm:"not null;default:0"`
+	Header    string    `json:"-"`
+	Body      []byte    `json:"-"`
+	CreatedAt time.Time `json:"created_at"`
+	ExpiresAt time.Time `json:"expires_at" gorm:"not null;index"`
 }
 
 func (t Ticket) GetUserID() uint{
@@ -0,0 +1,248 @@
+// Package problem describes API errors as RFC 7807 problem details
+package problem
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/go-playground/validator/v10"
+	"github.com/jinzhu/gorm"
+	"github.com/lib/pq"
+)
+
+// ContentType is the media type of problem responses
+const ContentType = "application/problem+json"
+
+// typeBase prefixes the code to build the problem type URI
+const typeBase = "urn:go-gin-postgres:problem:"
+
+// Stable error codes returned in the "code" field of every problem
+const (
+	CodeBadRequest           = "bad_request"
+	CodeMalformedBody        = "malformed_body"
+	CodeValidation           = "validation_failed"
+	CodeUnauthorized         = "unauthorized"
+	CodeForbidden            = "forbidden"
+	CodeNotFound             = "not_found"
+	CodeConflict             = "conflict"
+	CodeDuplicate            = "duplicate_value"
+	CodeConstraint           = "constraint_violation"
+	CodePreconditionFailed   = "precondition_failed"
+	CodeUnsupportedMediaType = "unsupported_media_type"
+	CodeTooManyRequests      = "too_many_requests"
+	CodeBatchAborted         = "batch_aborted"
+	CodeIdempotencyKeyReused = "idempotency_key_reused"
+	CodeDatabaseUnavailable  = "database_unavailable"
+	CodeInternal             = "internal_error"
+)
+
+// FieldError points at one invalid input field
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+// Problem is an API error. It is rendered as application/problem+json by middleware.ErrorMiddleware
+type Problem struct {
+	Type     string       `json:"type"`
+	Title    string       `json:"title"`
+	Status   int          `json:"status"`
+	Detail   string       `json:"detail,omitempty"`
+	Instance string       `json:"instance,omitempty"`
+	Code     string       `json:"code"`
+	Errors   []FieldError `json:"errors,omitempty"`
+
+	cause error
+}
+
+func (p *Problem) Error() string {
+	if p.cause != nil {
+		return fmt.Sprintf("%s: %s: %v", p.Code, p.Detail, p.cause)
+	}
+	return p.Code + ": " + p.Detail
+}
+
+// Unwrap returns the error the problem was built from, if any
+func (p *Problem) Unwrap() error {
+	return p.cause
+}
+
+// New builds a problem with a status, a stable code and a human readable detail
+func New(status int, code, detail string) *Problem {
+	return &Problem{
+		Type:   typeBase + code,
+		Title:  http.StatusText(status),
+		Status: status,
+		Detail: detail,
+		Code:   code,
+	}
+}
+
+// BadRequest reports a request that cannot be understood, such as a bad path or query parameter
+func BadRequest(detail string) *Problem {
+	return New(http.StatusBadRequest, CodeBadRequest, detail)
+}
+
+// Validation reports input that was understood but is not acceptable
+func Validation(detail string, fields ...FieldError) *Problem {
+	p := New(http.StatusBadRequest, CodeValidation, detail)
+	p.Errors = fields
+	return p
+}
+
+// NotFound reports a missing resource
+func NotFound(detail string) *Problem {
+	return New(http.StatusNotFound, CodeNotFound, detail)
+}
+
+// Conflict reports a request that clashes with the current state of a resource
+func Conflict(detail string) *Problem {
+	return New(http.StatusConflict, CodeConflict, detail)
+}
+
+// Forbidden reports a caller that may not perform the request
+func Forbidden(detail string) *Problem {
+	return New(http.StatusForbidden, CodeForbidden, detail)
+}
+
+// Unavailable reports a database the server cannot use for now; the cause is only logged
+func Unavailable(err error) *Problem {
+	p := New(http.StatusServiceUnavailable, CodeDatabaseUnavailable, "the database is unavailable, try again later")
+	p.cause = err
+	return p
+}
+
+// Internal reports an unexpected failure. Errors that From recognises, such as a database
+// outage, keep their own code; anything else becomes a 500 whose cause is only logged
+func Internal(detail string, err error) *Problem {
+	if p := classify(err); p != nil {
+		return p
+	}
+	p := New(http.StatusInternalServerError, CodeInternal, detail)
+	p.cause = err
+	return p
+}
+
+// From turns any error into a problem
+func From(err error) *Problem {
+	var p *Problem
+	if errors.As(err, &p) {
+		return p
+	}
+	if p := classify(err); p != nil {
+		return p
+	}
+	return Internal("internal server error", err)
+}
+
+// Abort stops the handler chain and records err for middleware.ErrorMiddleware to render
+func Abort(c *gin.Context, err error) {
+	c.Abort()
+	_ = c.Error(err)
+}
+
+// Write renders p as the response
+func Write(c *gin.Context, p *Problem) {
+	// Problems may be shared package values, so the instance is set on a copy
+	response := *p
+	if response.Instance == "" {
+		response.Instance = c.Request.URL.Path
+	}
+	body, err := json.Marshal(response)
+	if err != nil {
+		c.Status(http.StatusInternalServerError)
+		return
+	}
+	c.Data(p.Status, ContentType, body)
+}
+
+// keyDetail matches the detail Postgres gives for unique violations: Key (email)=(a@b.c) already exists.
+var keyDetail = regexp.MustCompile(`^Key \(([^)]+)\)=`)
+
+// classify maps errors from binding and the database to problems, or returns nil if err is not one of them
+func classify(err error) *Problem {
+	if err == nil {
+		return nil
+	}
+	if errs, ok := err.(gorm.Errors); ok {
+		for _, e := range errs {
+			if p := classify(e); p != nil {
+				return p
+			}
+		}
+		return nil
+	}
+	if gorm.IsRecordNotFoundError(err) {
+		return NotFound("record not found")
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if errors.As(err, &fieldErrs) {
+		fields := make([]FieldError, len(fieldErrs))
+		for i, fe := range fieldErrs {
+			fields[i] = FieldError{Field: fe.Field(), Reason: fe.Tag()}
+		}
+		return Validation("request body failed validation", fields...)
+	}
+	var syntaxErr *json.SyntaxError
+	var typeErr *json.UnmarshalTypeError
+	var timeErr *time.ParseError
+	switch {
+	case errors.As(err, &syntaxErr), errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return New(http.StatusBadRequest, CodeMalformedBody, "request body is not valid JSON")
+	case errors.As(err, &typeErr):
+		p := New(http.StatusBadRequest, CodeMalformedBody, "request body has a field of the wrong type")
+		p.Errors = []FieldError{{Field: typeErr.Field, Reason: "expected " + typeErr.Type.String()}}
+		return p
+	case errors.As(err, &timeErr):
+		return New(http.StatusBadRequest, CodeMalformedBody, "request body has an invalid time, use RFC 3339")
+	}
+
+	var pqErr *pq.Error
+	if errors.As(err, &pqErr) {
+		return classifyPostgres(pqErr)
+	}
+	var netErr net.Error
+	if errors.Is(err, driver.ErrBadConn) || errors.Is(err, sql.ErrConnDone) || errors.As(err, &netErr) {
+		return Unavailable(err)
+	}
+	return nil
+}
+
+// classifyPostgres maps Postgres error classes to problems
+func classifyPostgres(err *pq.Error) *Problem {
+	switch class := string(err.Code.Class()); {
+	case err.Code == "23505":
+		p := New(http.StatusConflict, CodeDuplicate, "a record with this value already exists")
+		if m := keyDetail.FindStringSubmatch(err.Detail); m != nil {
+			p.Detail = "a record with this " + m[1] + " already exists"
+			for _, column := range strings.Split(m[1], ", ") {
+				p.Errors = append(p.Errors, FieldError{Field: column, Reason: "unique"})
+			}
+		}
+		return p
+	case class == "23":
+		p := New(http.StatusConflict, CodeConstraint, "the change breaks a database constraint")
+		if err.Column != "" {
+			p.Errors = []FieldError{{Field: err.Column, Reason: err.Code.Name()}}
+		} else if err.Constraint != "" {
+			p.Detail = "the change breaks constraint " + err.Constraint
+		}
+		return p
+	case class == "22":
+		return Validation("a value has the wrong format for its field")
+	case class == "08", class == "53", class == "57":
+		return Unavailable(err)
+	}
+	return nil
+}
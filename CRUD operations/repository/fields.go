@@ -0,0 +1,83 @@
+package repository
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+	"sync"
+
+	"github.com/jinzhu/gorm"
+)
+
+// columns maps the column names of a struct type to its field indexes, the way gorm names columns
+type columns struct {
+	byName     map[string]int
+	primaryKey string
+}
+
+var columnCache sync.Map
+
+func columnsOf(t reflect.Type) *columns {
+	if cached, ok := columnCache.Load(t); ok {
+		return cached.(*columns)
+	}
+	cols := &columns{byName: map[string]int{}}
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		if !sf.IsExported() {
+			continue
+		}
+		name := gorm.ToColumnName(sf.Name)
+		cols.byName[name] = i
+		if cols.primaryKey == "" && strings.Contains(sf.Tag.Get("gorm"), "primary_key") {
+			cols.primaryKey = name
+		}
+	}
+	if _, ok := cols.byName["id"]; ok && cols.primaryKey == "" {
+		cols.primaryKey = "id"
+	}
+	columnCache.Store(t, cols)
+	return cols
+}
+
+// PrimaryKey returns the primary key column of T
+func PrimaryKey[T any]() string {
+	return columnsOf(reflect.TypeOf(new(T)).Elem()).primaryKey
+}
+
+// ColumnValue returns the value of column in record, a struct or a pointer to one
+func ColumnValue(record interface{}, column string) (interface{}, bool) {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	i, ok := columnsOf(v.Type()).byName[column]
+	if !ok {
+		return nil, false
+	}
+	return v.Field(i).Interface(), true
+}
+
+// PrimaryKeyValue returns the primary key of record
+func PrimaryKeyValue(record interface{}) interface{} {
+	v := reflect.Indirect(reflect.ValueOf(record))
+	value, _ := ColumnValue(record, columnsOf(v.Type()).primaryKey)
+	return value
+}
+
+// SetColumn sets column of the struct record points to, converting value to the field type
+func SetColumn(record interface{}, column string, value interface{}) error {
+	v := reflect.ValueOf(record).Elem()
+	i, ok := columnsOf(v.Type()).byName[column]
+	if !ok {
+		return fmt.Errorf("repository: %s has no column %q", v.Type().Name(), column)
+	}
+	field := v.Field(i)
+	given := reflect.ValueOf(value)
+	if !given.IsValid() {
+		field.Set(reflect.Zero(field.Type()))
+		return nil
+	}
+	if !given.Type().ConvertibleTo(field.Type()) {
+		return fmt.Errorf("repository: cannot set column %q of type %s to %v", column, field.Type(), value)
+	}
+	field.Set(given.Convert(field.Type()))
+	return nil
+}
@@ -0,0 +1,349 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"go-gin-postgres/models"
+
+	"github.com/jinzhu/gorm"
+)
+
+// Gorm is a Repository backed by a GORM connection
+type Gorm[T any] struct {
+	db *gorm.DB
+	// inTx is set on the repository handed to a Transaction's fn, whose writes each run in a savepoint
+	inTx bool
+}
+
+// NewGorm returns a repository of T on db
+func NewGorm[T any](db *gorm.DB) *Gorm[T] {
+	return &Gorm[T]{db: db}
+}
+
+// gorm v1 cannot cancel a running query, so the context is only checked before each call
+
+func (r *Gorm[T]) List(ctx context.Context, q Query) ([]T, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	records := []T{}
+	err := r.query(q).Find(&records).Error
+	return records, err
+}
+
+func (r *Gorm[T]) Query(ctx context.Context, q Query, each func(record T) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	rows, err := r.query(q).Model(new(T)).Rows()
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+	for rows.Next() {
+		var record T
+		if err := r.db.ScanRows(rows, &record); err != nil {
+			return err
+		}
+		if err := each(record); err != nil {
+			return err
+		}
+	}
+	return rows.Err()
+}
+
+func (r *Gorm[T]) Get(ctx context.Context, id interface{}, scope Scope) (T, error) {
+	var record T
+	if err := ctx.Err(); err != nil {
+		return record, err
+	}
+	err := r.scope(scope).Where(PrimaryKey[T]()+" = ?", id).First(&record).Error
+	return record, err
+}
+
+func (r *Gorm[T]) Create(ctx context.Context, record *T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	// gorm runs BeforeSave again on Create; running it first tells its refusal apart from a failed insert
+	if err := beforeSave(record); err != nil {
+		return err
+	}
+	return r.write(func() error {
+		return r.db.Create(record).Error
+	})
+}
+
+// CreateMany writes records with a single multi-row INSERT and reads the stored rows back into them.
+// Blank primary keys and blank fields with a database default are sent as DEFAULT
+func (r *Gorm[T]) CreateMany(ctx context.Context, records []T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if len(records) == 0 {
+		return nil
+	}
+	// Multi-row inserts bypass the model hooks
+	for i := range records {
+		if err := beforeSave(&records[i]); err != nil {
+			return err
+		}
+	}
+	scope := r.db.NewScope(new(T))
+	var columns []string
+	for _, field := range scope.Fields() {
+		if field.IsNormal && !field.IsIgnored {
+			columns = append(columns, scope.Quote(field.DBName))
+		}
+	}
+
+	var rows []string
+	var values []interface{}
+	for i := range records {
+		var placeholders []string
+		for _, field := range r.db.NewScope(&records[i]).Fields() {
+			if !field.IsNormal || field.IsIgnored {
+				continue
+			}
+			if field.IsBlank && (field.IsPrimaryKey || field.HasDefaultValue) {
+				placeholders = append(placeholders, "DEFAULT")
+				continue
+			}
+			placeholders = append(placeholders, "?")
+			values = append(values, field.Field.Interface())
+		}
+		rows = append(rows, "("+strings.Join(placeholders, ", ")+")")
+	}
+
+	sql := fmt.Sprintf("INSERT INTO %s (%s) VALUES %s RETURNING *",
+		scope.QuotedTableName(), strings.Join(columns, ", "), strings.Join(rows, ", "))
+	return r.write(func() error {
+		result, err := r.db.Raw(sql, values...).Rows()
+		if err != nil {
+			return err
+		}
+		defer result.Close()
+		for i := 0; result.Next() && i < len(records); i++ {
+			if err := r.db.ScanRows(result, &records[i]); err != nil {
+				return err
+			}
+		}
+		return result.Err()
+	})
+}
+
+func (r *Gorm[T]) Transaction(ctx context.Context, fn func(tx Repository[T]) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if r.inTx {
+		return fn(r)
+	}
+	tx := r.db.Begin()
+	if tx.Error != nil {
+		return tx.Error
+	}
+	defer tx.RollbackUnlessCommitted()
+	if err := fn(&Gorm[T]{db: tx, inTx: true}); err != nil {
+		return err
+	}
+	return tx.Commit().Error
+}
+
+// write runs one write. Inside a transaction it runs in a savepoint, so that a failed statement
+// does not abort the whole transaction
+func (r *Gorm[T]) write(fn func() error) error {
+	if !r.inTx {
+		return fn()
+	}
+	if err := r.db.Exec("SAVEPOINT repository_write").Error; err != nil {
+		return err
+	}
+	if err := fn(); err != nil {
+		if rollbackErr := r.db.Exec("ROLLBACK TO SAVEPOINT repository_write").Error; rollbackErr != nil {
+			return rollbackErr
+		}
+		return err
+	}
+	return r.db.Exec("RELEASE SAVEPOINT repository_write").Error
+}
+
+func (r *Gorm[T]) Update(ctx context.Context, id interface{}, record *T, version uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	pk := PrimaryKey[T]()
+	if err := SetColumn(record, pk, id); err != nil {
+		return err
+	}
+	if err := SetColumn(record, "version", version+1); err != nil {
+		return err
+	}
+	// UpdateColumns skips the model hooks, so BeforeSave is run here the way Save would
+	if err := beforeSave(record); err != nil {
+		return err
+	}
+	values := map[string]interface{}{}
+	for _, field := range r.db.NewScope(record).Fields() {
+		if field.IsNormal && !field.IsIgnored && !field.IsPrimaryKey {
+			values[field.DBName] = field.Field.Interface()
+		}
+	}
+	return r.write(func() error {
+		updated := r.db.Model(new(T)).Where(pk+" = ? AND version = ?", id, version).UpdateColumns(values)
+		if updated.Error != nil {
+			return updated.Error
+		}
+		if updated.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+}
+
+func (r *Gorm[T]) Delete(ctx context.Context, id interface{}, version uint, scope Scope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.write(func() error {
+		deleted := r.scope(scope).Where(PrimaryKey[T]()+" = ? AND version = ?", id, version).Delete(new(T))
+		if deleted.Error != nil {
+			return deleted.Error
+		}
+		if deleted.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+}
+
+func (r *Gorm[T]) Restore(ctx context.Context, id interface{}, scope Scope) (T, error) {
+	scope.Trashed = true
+	record, err := r.Get(ctx, id, scope)
+	if err != nil {
+		return record, err
+	}
+	version, _ := ColumnValue(record, "version")
+	err = r.write(func() error {
+		restored := r.db.Unscoped().Model(new(T)).Where(PrimaryKey[T]()+" = ? AND version = ?", id, version).
+			UpdateColumns(map[string]interface{}{"deleted_at": nil, "version": gorm.Expr("version + 1")})
+		if restored.Error != nil {
+			return restored.Error
+		}
+		if restored.RowsAffected == 0 {
+			return ErrVersionConflict
+		}
+		return nil
+	})
+	if err != nil {
+		return record, err
+	}
+	return r.Get(ctx, id, Scope{})
+}
+
+func (r *Gorm[T]) Purge(ctx context.Context, id interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return r.write(func() error {
+		purged := r.db.Unscoped().Where("deleted_at IS NOT NULL AND "+PrimaryKey[T]()+" = ?", id).Delete(new(T))
+		if purged.Error != nil {
+			return purged.Error
+		}
+		if purged.RowsAffected == 0 {
+			return ErrNotFound
+		}
+		return nil
+	})
+}
+
+// scope applies s to a query on T
+func (r *Gorm[T]) scope(s Scope) *gorm.DB {
+	db := r.db
+	if s.Trashed {
+		db = db.Unscoped().Where("deleted_at IS NOT NULL")
+	}
+	for _, condition := range s.Where {
+		if nullOp(condition.Op) {
+			db = db.Where(condition.Column + " " + condition.Op)
+			continue
+		}
+		clause := condition.Column + " " + condition.Op + " (?)"
+		if condition.Op == "ILIKE" {
+			clause += ` ESCAPE '\'`
+		}
+		db = db.Where(clause, condition.Value)
+	}
+	if s.Owner != nil {
+		db = OwnedBy[T](db, *s.Owner)
+	}
+	return db
+}
+
+// query applies q to a query on T
+func (r *Gorm[T]) query(q Query) *gorm.DB {
+	db := r.scope(q.Scope)
+	keys := append(append([]Order{}, q.Order...), Order{Column: PrimaryKey[T]()})
+	if len(q.After) > 0 {
+		clause, args := KeysetCondition(keys, q.After)
+		db = db.Where(clause, args...)
+	}
+	if len(q.Columns) > 0 {
+		db = db.Select(q.Columns)
+	}
+	if q.Limit > 0 {
+		db = db.Limit(q.Limit)
+	}
+	return OrderBy(db, keys)
+}
+
+// OwnedBy limits a query on T to the records of a user: the user itself, their tickets,
+// and the orders and payments of their live tickets
+func OwnedBy[T any](db *gorm.DB, userID uint) *gorm.DB {
+	var record T
+	switch any(record).(type) {
+	case models.User:
+		return db.Where("id = ?", userID)
+	case models.Ticket:
+		return db.Where("user_id = ?", userID)
+	case models.Order, models.Payment:
+		return db.Where("ticket_id IN (SELECT ticket_id FROM tickets WHERE user_id = ? AND deleted_at IS NULL)", userID)
+	}
+	return db
+}
+
+// OrderBy orders a query by keys
+func OrderBy(db *gorm.DB, keys []Order) *gorm.DB {
+	for _, key := range keys {
+		if key.Desc {
+			db = db.Order(key.Column + " DESC")
+		} else {
+			db = db.Order(key.Column)
+		}
+	}
+	return db
+}
+
+// KeysetCondition builds "rows after values" for keys as
+// (k1 > v1) OR (k1 = v1 AND k2 > v2) OR ..., with < for descending keys
+func KeysetCondition(keys []Order, values []interface{}) (string, []interface{}) {
+	var clauses []string
+	var args []interface{}
+	for i, key := range keys {
+		var parts []string
+		for j := 0; j < i; j++ {
+			parts = append(parts, keys[j].Column+" = ?")
+			args = append(args, values[j])
+		}
+		op := ">"
+		if key.Desc {
+			op = "<"
+		}
+		parts = append(parts, key.Column+" "+op+" ?")
+		args = append(args, values[i])
+		clauses = append(clauses, "("+strings.Join(parts, " AND ")+")")
+	}
+	return "(" + strings.Join(clauses, " OR ") + ")", args
+}
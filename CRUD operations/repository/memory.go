@@ -0,0 +1,438 @@
+package repository
+
+import (
+	"context"
+	"fmt"
+	"maps"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Memory is a Repository that keeps records in memory, for tests and local experiments.
+// It understands the same conditions as Gorm but enforces no database constraints.
+// Its transactions run one at a time and are rolled back on error, but writes outside them still show
+type Memory[T any] struct {
+	mu      sync.RWMutex
+	txMu    sync.Mutex
+	records map[string]T
+	nextID  uint64
+	owner   func(record T, userID uint) bool
+}
+
+// NewMemory returns an empty repository of T. owner decides which records a Scope.Owner sees;
+// when it is nil, an owner sees no records
+func NewMemory[T any](owner func(record T, userID uint) bool) *Memory[T] {
+	return &Memory[T]{records: map[string]T{}, owner: owner}
+}
+
+func (r *Memory[T]) List(ctx context.Context, q Query) ([]T, error) {
+	records := []T{}
+	err := r.Query(ctx, q, func(record T) error {
+		records = append(records, record)
+		return nil
+	})
+	return records, err
+}
+
+func (r *Memory[T]) Query(ctx context.Context, q Query, each func(record T) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.RLock()
+	var matched []T
+	for _, record := range r.records {
+		if r.sees(q.Scope, record) {
+			matched = append(matched, record)
+		}
+	}
+	r.mu.RUnlock()
+
+	keys := append(append([]Order{}, q.Order...), Order{Column: PrimaryKey[T]()})
+	sort.SliceStable(matched, func(i, j int) bool {
+		return compareKeys(keys, keyValues(keys, matched[i]), keyValues(keys, matched[j])) < 0
+	})
+	count := 0
+	for _, record := range matched {
+		if len(q.After) > 0 && compareKeys(keys, keyValues(keys, record), q.After) <= 0 {
+			continue
+		}
+		if q.Limit > 0 && count == q.Limit {
+			break
+		}
+		if err := each(record); err != nil {
+			return err
+		}
+		count++
+	}
+	return nil
+}
+
+func (r *Memory[T]) Get(ctx context.Context, id interface{}, scope Scope) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	record, ok := r.records[fmt.Sprint(id)]
+	if !ok || !r.sees(scope, record) {
+		return zero, ErrNotFound
+	}
+	return record, nil
+}
+
+func (r *Memory[T]) Create(ctx context.Context, record *T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	if err := beforeSave(record); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.create(record)
+}
+
+func (r *Memory[T]) CreateMany(ctx context.Context, records []T) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	for i := range records {
+		if err := beforeSave(&records[i]); err != nil {
+			return err
+		}
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	stored, nextID := maps.Clone(r.records), r.nextID
+	for i := range records {
+		if err := r.create(&records[i]); err != nil {
+			r.records, r.nextID = stored, nextID
+			return err
+		}
+	}
+	return nil
+}
+
+func (r *Memory[T]) Transaction(ctx context.Context, fn func(tx Repository[T]) error) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.txMu.Lock()
+	defer r.txMu.Unlock()
+	r.mu.RLock()
+	stored, nextID := maps.Clone(r.records), r.nextID
+	r.mu.RUnlock()
+	if err := fn(r); err != nil {
+		r.mu.Lock()
+		r.records, r.nextID = stored, nextID
+		r.mu.Unlock()
+		return err
+	}
+	return nil
+}
+
+// create stores a new record; the caller holds the write lock
+func (r *Memory[T]) create(record *T) error {
+	pk := PrimaryKey[T]()
+	if id := PrimaryKeyValue(record); reflect.ValueOf(id).IsZero() {
+		r.nextID++
+		if err := SetColumn(record, pk, r.nextID); err != nil {
+			return err
+		}
+	} else if n, ok := number(id); ok && uint64(n) > r.nextID {
+		r.nextID = uint64(n)
+	}
+	key := fmt.Sprint(PrimaryKeyValue(record))
+	if _, ok := r.records[key]; ok {
+		return fmt.Errorf("repository: duplicate %s %s", pk, key)
+	}
+	if version, ok := ColumnValue(record, "version"); ok && reflect.ValueOf(version).IsZero() {
+		_ = SetColumn(record, "version", 1)
+	}
+	r.records[key] = *record
+	return nil
+}
+
+func (r *Memory[T]) Update(ctx context.Context, id interface{}, record *T, version uint) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := fmt.Sprint(id)
+	stored, ok := r.records[key]
+	if !ok || !r.sees(Scope{}, stored) {
+		return ErrVersionConflict
+	}
+	if current, _ := ColumnValue(stored, "version"); current != version {
+		return ErrVersionConflict
+	}
+	if err := SetColumn(record, PrimaryKey[T](), id); err != nil {
+		return err
+	}
+	if err := SetColumn(record, "version", version+1); err != nil {
+		return err
+	}
+	if err := beforeSave(record); err != nil {
+		return err
+	}
+	r.records[key] = *record
+	return nil
+}
+
+func (r *Memory[T]) Delete(ctx context.Context, id interface{}, version uint, scope Scope) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := fmt.Sprint(id)
+	stored, ok := r.records[key]
+	if !ok || !r.sees(scope, stored) {
+		return ErrVersionConflict
+	}
+	if current, _ := ColumnValue(stored, "version"); current != version {
+		return ErrVersionConflict
+	}
+	now := time.Now()
+	if err := SetColumn(&stored, "deleted_at", &now); err != nil {
+		return err
+	}
+	r.records[key] = stored
+	return nil
+}
+
+func (r *Memory[T]) Restore(ctx context.Context, id interface{}, scope Scope) (T, error) {
+	var zero T
+	if err := ctx.Err(); err != nil {
+		return zero, err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := fmt.Sprint(id)
+	stored, ok := r.records[key]
+	scope.Trashed = true
+	if !ok || !r.sees(scope, stored) {
+		return zero, ErrNotFound
+	}
+	version, _ := ColumnValue(stored, "version")
+	n, _ := number(version)
+	if err := SetColumn(&stored, "deleted_at", nil); err != nil {
+		return zero, err
+	}
+	if err := SetColumn(&stored, "version", n+1); err != nil {
+		return zero, err
+	}
+	r.records[key] = stored
+	return stored, nil
+}
+
+func (r *Memory[T]) Purge(ctx context.Context, id interface{}) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	key := fmt.Sprint(id)
+	stored, ok := r.records[key]
+	if !ok || !r.sees(Scope{Trashed: true}, stored) {
+		return ErrNotFound
+	}
+	delete(r.records, key)
+	return nil
+}
+
+// sees reports whether record is in scope
+func (r *Memory[T]) sees(scope Scope, record T) bool {
+	deletedAt, _ := ColumnValue(record, "deleted_at")
+	trashed := deletedAt != nil && !reflect.ValueOf(deletedAt).IsNil()
+	if trashed != scope.Trashed {
+		return false
+	}
+	if scope.Owner != nil && (r.owner == nil || !r.owner(record, *scope.Owner)) {
+		return false
+	}
+	for _, condition := range scope.Where {
+		value, ok := ColumnValue(record, condition.Column)
+		if !ok || !matches(value, condition) {
+			return false
+		}
+	}
+	return true
+}
+
+// matches evaluates condition against a column value with SQL semantics: NULL matches nothing
+func matches(value interface{}, condition Condition) bool {
+	value, ok := deref(value)
+	if nullOp(condition.Op) {
+		return ok == (condition.Op == "IS NOT NULL")
+	}
+	if !ok {
+		return false
+	}
+	switch condition.Op {
+	case "ILIKE":
+		pattern, _ := condition.Value.(string)
+		return likePattern(pattern).MatchString(fmt.Sprint(value))
+	case "IN":
+		list := reflect.ValueOf(condition.Value)
+		if list.Kind() != reflect.Slice {
+			return false
+		}
+		for i := 0; i < list.Len(); i++ {
+			if cmp, ok := compare(value, list.Index(i).Interface()); ok && cmp == 0 {
+				return true
+			}
+		}
+		return false
+	}
+	cmp, ok := compare(value, condition.Value)
+	if !ok {
+		return false
+	}
+	switch condition.Op {
+	case "=":
+		return cmp == 0
+	case "<>", "!=":
+		return cmp != 0
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	}
+	return false
+}
+
+// likePattern turns an SQL LIKE pattern with backslash escapes into a case-insensitive regular expression
+func likePattern(pattern string) *regexp.Regexp {
+	var b strings.Builder
+	b.WriteString("(?is)^")
+	escaped := false
+	for _, r := range pattern {
+		switch {
+		case escaped:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+			escaped = false
+		case r == '\\':
+			escaped = true
+		case r == '%':
+			b.WriteString(".*")
+		case r == '_':
+			b.WriteString(".")
+		default:
+			b.WriteString(regexp.QuoteMeta(string(r)))
+		}
+	}
+	b.WriteString("$")
+	return regexp.MustCompile(b.String())
+}
+
+func keyValues(keys []Order, record interface{}) []interface{} {
+	values := make([]interface{}, len(keys))
+	for i, key := range keys {
+		values[i], _ = ColumnValue(record, key.Column)
+	}
+	return values
+}
+
+// compareKeys orders two rows of key values, honouring descending keys
+func compareKeys(keys []Order, a, b []interface{}) int {
+	for i, key := range keys {
+		cmp, _ := compare(a[i], b[i])
+		if key.Desc {
+			cmp = -cmp
+		}
+		if cmp != 0 {
+			return cmp
+		}
+	}
+	return 0
+}
+
+// deref unwraps pointers, reporting false for nil
+func deref(value interface{}) (interface{}, bool) {
+	v := reflect.ValueOf(value)
+	for v.IsValid() && v.Kind() == reflect.Ptr {
+		if v.IsNil() {
+			return nil, false
+		}
+		v = v.Elem()
+	}
+	if !v.IsValid() {
+		return nil, false
+	}
+	return v.Interface(), true
+}
+
+// compare orders two values of compatible types. Numbers compare across types, and times compare
+// with RFC 3339 strings, which is how they come back from a decoded cursor
+func compare(a, b interface{}) (int, bool) {
+	a, okA := deref(a)
+	b, okB := deref(b)
+	if !okA || !okB {
+		return 0, false
+	}
+	if t, ok := a.(time.Time); ok {
+		other, ok := b.(time.Time)
+		if s, isString := b.(string); isString {
+			parsed, err := time.Parse(time.RFC3339Nano, s)
+			other, ok = parsed, err == nil
+		}
+		if !ok {
+			return 0, false
+		}
+		return t.Compare(other), true
+	}
+	if x, ok := number(a); ok {
+		y, ok := number(b)
+		if !ok {
+			return 0, false
+		}
+		switch {
+		case x < y:
+			return -1, true
+		case x > y:
+			return 1, true
+		}
+		return 0, true
+	}
+	switch x := a.(type) {
+	case string:
+		y, ok := b.(string)
+		return strings.Compare(x, y), ok
+	case bool:
+		y, ok := b.(bool)
+		switch {
+		case !ok:
+			return 0, false
+		case x == y:
+			return 0, true
+		case y:
+			return -1, true
+		}
+		return 1, true
+	}
+	return 0, false
+}
+
+func number(value interface{}) (float64, bool) {
+	v := reflect.ValueOf(value)
+	switch v.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return float64(v.Int()), true
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		return float64(v.Uint()), true
+	case reflect.Float32, reflect.Float64:
+		return v.Float(), true
+	}
+	return 0, false
+}
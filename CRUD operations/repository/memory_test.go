@@ -0,0 +1,217 @@
+package repository_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"go-gin-postgres/models"
+	"go-gin-postgres/repository"
+)
+
+// orders returns a repository holding orders of tickets 1 and 2
+func orders(t *testing.T) *repository.Memory[models.Order] {
+	t.Helper()
+	repo := repository.NewMemory(func(order models.Order, userID uint) bool {
+		return order.TicketID == userID
+	})
+	for _, order := range []models.Order{
+		{TicketID: 1, MenuItem: "Coffee", Quantity: 2, Price: 3.5},
+		{TicketID: 1, MenuItem: "Iced coffee", Quantity: 1, Price: 4},
+		{TicketID: 2, MenuItem: "Tea", Quantity: 1, Price: 2.5},
+		{TicketID: 2, MenuItem: "Cake", Quantity: 3, Price: 4},
+	} {
+		if err := repo.Create(context.Background(), &order); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return repo
+}
+
+func ids(records []models.Order) []uint {
+	ids := make([]uint, len(records))
+	for i, record := range records {
+		ids[i] = record.OrderID
+	}
+	return ids
+}
+
+func equal(a, b []uint) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestMemoryConditions(t *testing.T) {
+	repo := orders(t)
+	for _, tc := range []struct {
+		name      string
+		condition repository.Condition
+		want      []uint
+	}{
+		{"equal", repository.Condition{Column: "ticket_id", Op: "=", Value: 2}, []uint{3, 4}},
+		{"not equal", repository.Condition{Column: "ticket_id", Op: "<>", Value: 2}, []uint{1, 2}},
+		{"greater", repository.Condition{Column: "price", Op: ">", Value: 3.5}, []uint{2, 4}},
+		{"at most", repository.Condition{Column: "quantity", Op: "<=", Value: 1}, []uint{2, 3}},
+		{"ilike", repository.Condition{Column: "menu_item", Op: "ILIKE", Value: "%COFFEE"}, []uint{1, 2}},
+		{"ilike escapes", repository.Condition{Column: "menu_item", Op: "ILIKE", Value: `\%`}, []uint{}},
+		{"in", repository.Condition{Column: "menu_item", Op: "IN", Value: []string{"Tea", "Cake"}}, []uint{3, 4}},
+		{"is null", repository.Condition{Column: "deleted_at", Op: "IS NULL"}, []uint{1, 2, 3, 4}},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			found, err := repo.List(context.Background(), repository.Query{Scope: repository.Scope{Where: []repository.Condition{tc.condition}}})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !equal(ids(found), tc.want) {
+				t.Errorf("found %v, want %v", ids(found), tc.want)
+			}
+		})
+	}
+}
+
+func TestMemoryKeysetPages(t *testing.T) {
+	repo := orders(t)
+	q := repository.Query{Order: []repository.Order{{Column: "price", Desc: true}}, Limit: 3}
+
+	first, err := repo.List(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Equal prices are ordered by primary key
+	if !equal(ids(first), []uint{2, 4, 1}) {
+		t.Fatalf("first page %v, want [2 4 1]", ids(first))
+	}
+	last := first[len(first)-1]
+	q.After = []interface{}{last.Price, last.OrderID}
+	second, err := repo.List(context.Background(), q)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !equal(ids(second), []uint{3}) {
+		t.Errorf("second page %v, want [3]", ids(second))
+	}
+}
+
+func TestMemoryOwnerScope(t *testing.T) {
+	repo := orders(t)
+	owner := uint(1)
+	scope := repository.Scope{Owner: &owner}
+
+	found, _ := repo.List(context.Background(), repository.Query{Scope: scope})
+	if !equal(ids(found), []uint{1, 2}) {
+		t.Errorf("owner 1 sees %v, want [1 2]", ids(found))
+	}
+	if _, err := repo.Get(context.Background(), 3, scope); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("owner 1 read another's order: %v", err)
+	}
+	if err := repo.Delete(context.Background(), 3, 1, scope); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("owner 1 deleted another's order: %v", err)
+	}
+}
+
+func TestMemoryVersions(t *testing.T) {
+	repo := orders(t)
+	ctx := context.Background()
+
+	update := models.Order{TicketID: 1, MenuItem: "Flat white", Quantity: 1, Price: 4}
+	if err := repo.Update(ctx, 1, &update, 2); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("a stale update returned %v", err)
+	}
+	if err := repo.Update(ctx, 1, &update, 1); err != nil {
+		t.Fatal(err)
+	}
+	if stored, _ := repo.Get(ctx, 1, repository.Scope{}); stored.MenuItem != "Flat white" || stored.Version != 2 || update.OrderID != 1 {
+		t.Errorf("stored %+v after an update", stored)
+	}
+
+	if err := repo.Delete(ctx, 1, 1, repository.Scope{}); !errors.Is(err, repository.ErrVersionConflict) {
+		t.Errorf("a stale delete returned %v", err)
+	}
+	if err := repo.Delete(ctx, 1, 2, repository.Scope{}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, 1, repository.Scope{}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("a deleted order is still live: %v", err)
+	}
+	trashed, err := repo.Get(ctx, 1, repository.Scope{Trashed: true})
+	if err != nil || trashed.DeletedAt == nil || trashed.DeletedAt.After(time.Now()) {
+		t.Errorf("the trash holds %+v, %v", trashed, err)
+	}
+
+	if err := repo.Purge(ctx, 2); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("purging a live order returned %v", err)
+	}
+	restored, err := repo.Restore(ctx, 1, repository.Scope{})
+	if err != nil || restored.DeletedAt != nil || restored.Version != 3 {
+		t.Errorf("restored %+v, %v", restored, err)
+	}
+	if _, err := repo.Restore(ctx, 1, repository.Scope{}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("restoring a live order returned %v", err)
+	}
+
+	_ = repo.Delete(ctx, 1, 3, repository.Scope{})
+	if err := repo.Purge(ctx, 1); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := repo.Get(ctx, 1, repository.Scope{Trashed: true}); !errors.Is(err, repository.ErrNotFound) {
+		t.Errorf("a purged order is still in the trash: %v", err)
+	}
+}
+
+func TestMemoryTransactionRollsBack(t *testing.T) {
+	repo := orders(t)
+	ctx := context.Background()
+	failed := errors.New("failed")
+
+	err := repo.Transaction(ctx, func(tx repository.Repository[models.Order]) error {
+		if err := tx.Create(ctx, &models.Order{TicketID: 1, MenuItem: "Water"}); err != nil {
+			return err
+		}
+		if err := tx.Delete(ctx, 3, 1, repository.Scope{}); err != nil {
+			return err
+		}
+		return failed
+	})
+	if !errors.Is(err, failed) {
+		t.Fatalf("Transaction returned %v", err)
+	}
+	found, _ := repo.List(ctx, repository.Query{})
+	if !equal(ids(found), []uint{1, 2, 3, 4}) {
+		t.Errorf("after a rollback the repository holds %v", ids(found))
+	}
+
+	// Keys handed out inside the rolled back transaction are handed out again
+	order := models.Order{TicketID: 1, MenuItem: "Water"}
+	if err := repo.Create(ctx, &order); err != nil || order.OrderID != 5 {
+		t.Errorf("created order %d, %v, want 5", order.OrderID, err)
+	}
+}
+
+func TestMemoryCreateManyIsAllOrNothing(t *testing.T) {
+	repo := repository.NewMemory[models.User](nil)
+	ctx := context.Background()
+
+	err := repo.CreateMany(ctx, []models.User{{Name: "Ada", Password: "hash"}, {Name: "Bob"}})
+	if !errors.Is(err, repository.ErrInvalid) {
+		t.Errorf("CreateMany returned %v, want the BeforeSave error", err)
+	}
+	if found, _ := repo.List(ctx, repository.Query{}); len(found) != 0 {
+		t.Errorf("a refused batch stored %d users", len(found))
+	}
+
+	err = repo.CreateMany(ctx, []models.User{{ID: 7, Name: "Ada", Password: "hash"}, {ID: 7, Name: "Bob", Password: "hash"}})
+	if err == nil {
+		t.Error("CreateMany stored two users with the same id")
+	}
+	if found, _ := repo.List(ctx, repository.Query{}); len(found) != 0 {
+		t.Errorf("a failed batch stored %d users", len(found))
+	}
+}
@@ -0,0 +1,105 @@
+// Package repository hides where records are stored behind a small generic interface,
+// with a GORM implementation for Postgres and an in-memory one
+package repository
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrNotFound is returned when no record matches. It is gorm's error, so that callers
+// checking gorm.IsRecordNotFoundError work with every implementation
+var ErrNotFound = gorm.ErrRecordNotFound
+
+// ErrVersionConflict is returned by Update and Delete when the stored version is not the expected one
+var ErrVersionConflict = errors.New("repository: the record has a different version")
+
+// ErrInvalid wraps the error a record's BeforeSave hook refuses it with
+var ErrInvalid = errors.New("repository: invalid record")
+
+// Condition is one WHERE clause, Column Op Value, with Op one of = <> > >= < <= ILIKE IN IS NULL IS NOT NULL.
+// IN takes a slice; ILIKE takes a pattern with % and _ wildcards, escaped with a backslash;
+// IS NULL and IS NOT NULL take no value
+type Condition struct {
+	Column string
+	Op     string
+	Value  interface{}
+}
+
+// Order is one column of an ordering
+type Order struct {
+	Column string
+	Desc   bool
+}
+
+// Scope narrows the records an operation sees
+type Scope struct {
+	Where []Condition
+	// Owner limits the records to those belonging to this user id; nil sees every record
+	Owner *uint
+	// Trashed sees the soft-deleted records instead of the live ones
+	Trashed bool
+}
+
+// Query selects a keyset page of records
+type Query struct {
+	Scope
+	// Order is the ordering; the primary key always follows it to break ties
+	Order []Order
+	// After holds the Order values and then the primary key of the last row seen; nil starts from the top
+	After []interface{}
+	// Limit caps the number of records; 0 means no limit
+	Limit int
+	// Columns restricts the columns read, which implementations may ignore; nil reads all of them
+	Columns []string
+}
+
+// Repository stores records of T. T has a primary key, and a Version and DeletedAt field
+// for Update and Delete
+type Repository[T any] interface {
+	// List returns the records matching q in order
+	List(ctx context.Context, q Query) ([]T, error)
+	// Query calls each with every record matching q in order, without holding them all in memory.
+	// It stops at the first error each returns
+	Query(ctx context.Context, q Query, each func(record T) error) error
+	// Get returns the record with primary key id, or ErrNotFound if scope does not see it
+	Get(ctx context.Context, id interface{}, scope Scope) (T, error)
+	// Create inserts record and fills in its primary key and defaults
+	Create(ctx context.Context, record *T) error
+	// Update replaces the record with primary key id by record if it still has version, and stores
+	// it as the next version. record gets id as its primary key and the new version
+	Update(ctx context.Context, id interface{}, record *T, version uint) error
+	// Delete moves the record with primary key id to the trash if it still has version
+	Delete(ctx context.Context, id interface{}, version uint, scope Scope) error
+	// Restore brings the record with primary key id back from the trash if scope sees it there, and
+	// returns it as its next version
+	Restore(ctx context.Context, id interface{}, scope Scope) (T, error)
+	// Purge permanently deletes the record with primary key id if it is in the trash
+	Purge(ctx context.Context, id interface{}) error
+	// CreateMany inserts records with as few statements as the store allows and fills in their
+	// primary keys and defaults. Either every record is stored or none is
+	CreateMany(ctx context.Context, records []T) error
+	// Transaction runs fn on a repository whose writes are committed together when fn returns nil and
+	// discarded when it returns an error. A write that fails inside it leaves the writes before it in place,
+	// so that fn can carry on with other records
+	Transaction(ctx context.Context, fn func(tx Repository[T]) error) error
+}
+
+// nullOp reports whether op is IS NULL or IS NOT NULL, which take no value
+func nullOp(op string) bool {
+	return op == "IS NULL" || op == "IS NOT NULL"
+}
+
+// beforeSave runs the BeforeSave hook of record, the way gorm does on every save.
+// Its error is wrapped in ErrInvalid, so that callers can tell a refused record from a failed write
+func beforeSave(record interface{}) error {
+	if hook, ok := record.(interface{ BeforeSave() error }); ok {
+		if err := hook.BeforeSave(); err != nil {
+			return fmt.Errorf("%w: %v", ErrInvalid, err)
+		}
+	}
+	return nil
+}
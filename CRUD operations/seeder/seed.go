@@ -3,16 +3,22 @@ package main
 import (
 	"log"
 	"math/rand"
+	"os"
 	"time"
 
 	"github.com/brianvoe/gofakeit/v6"
 	"github.com/jinzhu/gorm"
 	"github.com/sirupsen/logrus"
 
+	"go-gin-postgres/auth"
+	"go-gin-postgres/config"
 	"go-gin-postgres/database"
 	"go-gin-postgres/models"
 )
 
+// seedPassword is the plain-text password of every seeded user
+const seedPassword = "password123"
+
 // Seeder is responsible for seeding data into the database
 type Seeder struct {
 	DB *gorm.DB
@@ -26,13 +32,19 @@ func NewSeeder(db *gorm.DB) *Seeder {
 }
 
 // SeedUsers seeds user data into the database
+// Every seeded user shares one hashed password, since hashing per row would dominate the run time
 func (s *Seeder) SeedUsers(numUsers int) error {
+	password, err := models.HashPassword(seedPassword)
+	if err != nil {
+		return err
+	}
 	for i := 0; i < numUsers; i++ {
 		user := models.User{
 			Name:     gofakeit.Name(),
 			Dob:      stripTime(gofakeit.DateRange(time.Now().AddDate(-50, 0, 0), time.Now().AddDate(-18, 0, 0))),
 			Email:    gofakeit.Email(),
-			Password: gofakeit.Password(true, true, true, false, false, 12),
+			Password: password,
+			Role:     auth.RoleCustomer,
 		}
 		if err := s.DB.Create(&user).Error; err != nil {
 			log.Printf("Failed to create user: %v", err)
@@ -126,9 +138,17 @@ func main() {
 	logger.SetFormatter(&logrus.TextFormatter{})
 	logger.SetLevel(logrus.DebugLevel) // Set log level to debug for capturing SQL queries
 
-	
+	// The seeder only needs the database settings
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		log.Fatalf("Failed to load configuration: %v", err)
+	}
+	if err := cfg.Database.Validate(); err != nil {
+		log.Fatalf("Invalid configuration: %v", err)
+	}
+
 	// Initialize the database
-	db, err := database.Initialize(logger)
+	db, _, err := database.Initialize(cfg.Database, logger, models.Resources()...)
 	if err != nil {
 		// Handle error if database initialization fails
 		log.Fatalf("Failed to initialize database: %v", err)